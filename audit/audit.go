@@ -0,0 +1,316 @@
+// Package audit records the mutating operations of the client in an append-only, hash-chained trail, so auditors
+// can tell who changed which cluster and when, and whether the trail was tampered with.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/azure-aks-client/redact"
+)
+
+// Operations recorded by the client
+const (
+	OperationCreateUpdate = "CreateUpdateCluster"
+	OperationDelete       = "DeleteCluster"
+)
+
+// Actor is the identity that called ARM
+type Actor struct {
+	ClientId string `json:"clientId,omitempty"`
+	ObjectId string `json:"objectId,omitempty"`
+	TenantId string `json:"tenantId,omitempty"`
+}
+
+// Target is the cluster an operation changed
+type Target struct {
+	SubscriptionId string `json:"subscriptionId,omitempty"`
+	ResourceGroup  string `json:"resourceGroup,omitempty"`
+	Name           string `json:"name,omitempty"`
+}
+
+// Result is the outcome of an operation
+type Result struct {
+	Success    bool   `json:"success"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	RequestID  string `json:"requestId,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Record is an entry of the trail. Sequence, PrevHash and Hash are set by the Trail; Hash is the SHA-256 of the
+// record's JSON with an empty Hash, so it covers the hash of the previous record too.
+type Record struct {
+	Sequence   uint64          `json:"sequence"`
+	Operation  string          `json:"operation"`
+	Actor      Actor           `json:"actor"`
+	Target     Target          `json:"target"`
+	Request    json.RawMessage `json:"request,omitempty"`
+	Result     Result          `json:"result"`
+	StartTime  time.Time       `json:"startTime"`
+	EndTime    time.Time       `json:"endTime"`
+	DurationMs int64           `json:"durationMs"`
+	PrevHash   string          `json:"prevHash"`
+	Hash       string          `json:"hash"`
+}
+
+// hash returns the hash of the record with an empty Hash
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	b, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Sink stores the records of a trail in order
+type Sink interface {
+	Write(r *Record) error
+}
+
+// Resumable is implemented by the sinks that already hold records, like the FileSink of an existing file, so a new
+// Trail continues their chain
+type Resumable interface {
+	// Last returns the last record of the sink, or nil if it's empty
+	Last() (*Record, error)
+}
+
+// Trail chains the records and writes them to its sink
+type Trail struct {
+	sink     Sink
+	redactor *redact.Redactor
+	lock     sync.Mutex
+	sequence uint64
+	lastHash string
+}
+
+// NewTrail creates a trail writing to sink. If the sink is Resumable, the trail continues its chain.
+func NewTrail(sink Sink) (*Trail, error) {
+	t := &Trail{sink: sink}
+	if resumable, ok := sink.(Resumable); ok {
+		last, err := resumable.Last()
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			t.sequence, t.lastHash = last.Sequence, last.Hash
+		}
+	}
+	return t, nil
+}
+
+// Head returns the sequence and the hash of the last record of the trail, to anchor its verification, see Anchor
+func (t *Trail) Head() (uint64, string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.sequence, t.lastHash
+}
+
+// SetRedactor masks the requests of the records with r instead of redact.Default
+func (t *Trail) SetRedactor(r *redact.Redactor) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.redactor = r
+}
+
+// Record masks request, chains the record and writes it to the sink. r.Request is overwritten by the masked request.
+func (t *Trail) Record(r *Record, request interface{}) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	redactor := t.redactor
+	if redactor == nil {
+		redactor = redact.Default
+	}
+	r.Request = nil
+	if request != nil {
+		b, err := json.Marshal(redactor.Value(request))
+		if err != nil {
+			return err
+		}
+		r.Request = b
+	}
+	r.StartTime, r.EndTime = r.StartTime.UTC(), r.EndTime.UTC()
+	r.DurationMs = int64(r.EndTime.Sub(r.StartTime) / time.Millisecond)
+	r.Sequence = t.sequence + 1
+	r.PrevHash = t.lastHash
+
+	hash, err := r.hash()
+	if err != nil {
+		return err
+	}
+	r.Hash = hash
+	if err := t.sink.Write(r); err != nil {
+		return err
+	}
+	t.sequence, t.lastHash = r.Sequence, r.Hash
+	return nil
+}
+
+// WriterSink writes the records as JSON lines to an io.Writer
+type WriterSink struct {
+	w    io.Writer
+	lock sync.Mutex
+}
+
+// NewWriterSink creates a sink writing to w
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{w: w}
+}
+
+func (s *WriterSink) Write(r *Record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+// FileSink appends the records as JSON lines to a file. It's Resumable, so a trail continues the chain of the file.
+type FileSink struct {
+	WriterSink
+	file *os.File
+	last *Record
+}
+
+// NewFileSink opens or creates the JSONL file at path, readable only by the owner
+func NewFileSink(path string) (*FileSink, error) {
+	last, err := lastRecord(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{WriterSink: WriterSink{w: f}, file: f, last: last}, nil
+}
+
+// Last returns the last record of the file when it was opened
+func (s *FileSink) Last() (*Record, error) {
+	return s.last, nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
+
+// lastRecord returns the last record of the JSONL file at path, or nil if it doesn't exist or is empty
+func lastRecord(path string) (*Record, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last *Record
+	err = readRecords(f, func(r *Record) error {
+		last = r
+		return nil
+	})
+	return last, err
+}
+
+// readRecords calls fn with the records of the JSON lines of r, skipping empty lines
+func readRecords(r io.Reader, fn func(r *Record) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		b := bytes.TrimSpace(scanner.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		record := &Record{}
+		if err := json.Unmarshal(b, record); err != nil {
+			return fmt.Errorf("invalid record in line %d: %s", line, err)
+		}
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// TamperedError is returned by Verify for the first record that breaks the chain
+type TamperedError struct {
+	Sequence uint64
+	Reason   string
+}
+
+func (e *TamperedError) Error() string {
+	return fmt.Sprintf("audit record %d was tampered with: %s", e.Sequence, e.Reason)
+}
+
+// Anchor pins the ends of a chain for VerifyChain, e.g. with hashes kept out of reach of the writer of the trail
+type Anchor struct {
+	// PrevHash is the hash of the record preceding the first one of a chain starting in the middle, e.g. after
+	// rotation. If empty, the chain must start at sequence 1.
+	PrevHash string
+	// LastHash is the hash the chain must end with, see Trail.Head. Records removed from the end of the chain are
+	// only detected with it.
+	LastHash string
+}
+
+// Verify checks the chain of the JSON lines of r from sequence 1, see VerifyChain
+func Verify(r io.Reader) (int, error) {
+	return VerifyChain(r, Anchor{})
+}
+
+// VerifyChain checks the chain of the JSON lines of r: the sequence, the previous hash and the hash of every record,
+// and the ends of the chain against the anchor. It returns the number of records, and a *TamperedError for the first
+// record that doesn't match.
+func VerifyChain(r io.Reader, anchor Anchor) (int, error) {
+	count := 0
+	var sequence uint64
+	prevHash := anchor.PrevHash
+	err := readRecords(r, func(record *Record) error {
+		if count == 0 && len(anchor.PrevHash) == 0 && record.Sequence != 1 {
+			return &TamperedError{Sequence: record.Sequence, Reason: "chain doesn't start at sequence 1"}
+		}
+		if count != 0 && record.Sequence != sequence+1 {
+			return &TamperedError{Sequence: record.Sequence, Reason: fmt.Sprintf("expected sequence %d", sequence+1)}
+		}
+		if record.PrevHash != prevHash {
+			return &TamperedError{Sequence: record.Sequence, Reason: "previous hash doesn't match"}
+		}
+		hash, err := record.hash()
+		if err != nil {
+			return err
+		}
+		if hash != record.Hash {
+			return &TamperedError{Sequence: record.Sequence, Reason: "hash doesn't match"}
+		}
+		count++
+		sequence, prevHash = record.Sequence, record.Hash
+		return nil
+	})
+	if err == nil && len(anchor.LastHash) != 0 && prevHash != anchor.LastHash {
+		return count, &TamperedError{Sequence: sequence, Reason: "chain doesn't end with the anchored last record"}
+	}
+	return count, err
+}
+
+// VerifyFile checks the chain of the JSONL file at path, see Verify
+func VerifyFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return Verify(f)
+}
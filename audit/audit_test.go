@@ -0,0 +1,149 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testRequest struct {
+	Name         string
+	ClientSecret string
+}
+
+func TestTrail(t *testing.T) {
+	var out bytes.Buffer
+	trail, err := NewTrail(NewWriterSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	for _, operation := range []string{OperationCreateUpdate, OperationDelete} {
+		record := &Record{
+			Operation: operation,
+			Actor:     Actor{ClientId: "clientId"},
+			Target:    Target{ResourceGroup: "rg", Name: "cluster"},
+			Result:    Result{Success: true},
+			StartTime: start,
+			EndTime:   start.Add(1500 * time.Millisecond),
+		}
+		if err := trail.Record(record, testRequest{Name: "cluster", ClientSecret: "topSecret"}); err != nil {
+			t.Fatal(err)
+		}
+		if record.DurationMs != 1500 || len(record.Hash) == 0 {
+			t.Errorf("Unexpected record: %+v", record)
+		}
+	}
+
+	if strings.Contains(out.String(), "topSecret") {
+		t.Errorf("Expected the request masked, but got %s", out.String())
+	}
+	if count, err := Verify(bytes.NewReader(out.Bytes())); err != nil || count != 2 {
+		t.Errorf("Expected 2 valid records, but got %d: %v", count, err)
+	}
+
+	_, head := trail.Head()
+	var records []*Record
+	readRecords(bytes.NewReader(out.Bytes()), func(r *Record) error {
+		records = append(records, r)
+		return nil
+	})
+
+	cases := []struct {
+		name    string
+		tamper  func(lines []string) []string
+		anchor  Anchor
+		expSeq  uint64
+		expText string
+	}{
+		{
+			name: "changed record",
+			tamper: func(lines []string) []string {
+				lines[0] = strings.Replace(lines[0], `"success":true`, `"success":false`, 1)
+				return lines
+			},
+			expSeq: 1, expText: "hash doesn't match",
+		},
+		{
+			name: "removed first record",
+			tamper: func(lines []string) []string {
+				return lines[1:]
+			},
+			expSeq: 2, expText: "chain doesn't start at sequence 1",
+		},
+		{
+			name: "rotated chain",
+			tamper: func(lines []string) []string {
+				return lines[1:]
+			},
+			anchor: Anchor{PrevHash: records[0].Hash, LastHash: head},
+		},
+		{
+			name: "removed last record",
+			tamper: func(lines []string) []string {
+				return lines[:1]
+			},
+			anchor: Anchor{LastHash: head},
+			expSeq: 1, expText: "chain doesn't end with the anchored last record",
+		},
+		{
+			name: "reordered records",
+			tamper: func(lines []string) []string {
+				return []string{lines[1], lines[0]}
+			},
+			expSeq: 2, expText: "chain doesn't start at sequence 1",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lines := tc.tamper(strings.Split(strings.TrimSpace(out.String()), "\n"))
+			_, err := VerifyChain(strings.NewReader(strings.Join(lines, "\n")), tc.anchor)
+			if len(tc.expText) == 0 {
+				if err != nil {
+					t.Errorf("Expected valid chain, but got %s", err)
+				}
+				return
+			}
+			tampered, ok := err.(*TamperedError)
+			if !ok || tampered.Sequence != tc.expSeq || tampered.Reason != tc.expText {
+				t.Errorf("Expected tampering of record %d: %s, but got %v", tc.expSeq, tc.expText, err)
+			}
+		})
+	}
+}
+
+func TestFileSinkResumes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+
+	for i := 0; i < 2; i++ {
+		sink, err := NewFileSink(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trail, err := NewTrail(sink)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := trail.Record(&Record{Operation: OperationDelete, StartTime: time.Now(), EndTime: time.Now()}, nil); err != nil {
+			t.Fatal(err)
+		}
+		sink.Close()
+	}
+
+	if count, err := VerifyFile(path); err != nil || count != 2 {
+		t.Errorf("Expected 2 valid records, but got %d: %v", count, err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected file mode 0600, but got %v", info.Mode())
+	}
+}
@@ -5,56 +5,127 @@ import (
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2016-06-01/subscriptions"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/banzaicloud/azure-aks-client/audit"
 	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/azure-aks-client/hooks"
+	"github.com/banzaicloud/azure-aks-client/locking"
+	"github.com/banzaicloud/azure-aks-client/logging"
+	"github.com/banzaicloud/azure-aks-client/ratelimit"
+	"github.com/banzaicloud/azure-aks-client/redact"
+	"github.com/banzaicloud/azure-aks-client/retry"
+	"github.com/banzaicloud/azure-aks-client/secrets"
 	"github.com/banzaicloud/azure-aks-client/utils"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"strings"
 )
 
+// BaseUrl is the Resource Manager endpoint of the public Azure cloud. Clients of other clouds report theirs by
+// GetBaseUrl.
 const BaseUrl = "https://management.azure.com"
 
 type AKSClient struct {
-	azureSdk *cluster.Sdk
-	logger   *logrus.Logger
-	clientId string
-	secret   string
+	azureSdk        *cluster.Sdk
+	logger          logging.Logger
+	clientId        string
+	clusterIdentity *cluster.ClusterIdentity
+	redactor        *redact.Redactor
+	auditTrail      *audit.Trail
+	hooks           *hooks.Registry
+	locks           *locking.Manager
+	retryPolicy     *retry.Policy
+	rateLimiter     *ratelimit.Limiter
+	ctx             context.Context
 }
 
-// GetAKSClient creates an *AKSClient instance with the passed credentials and default logger
-func GetAKSClient(credentials *cluster.AKSCredential) (*AKSClient, error) {
+// GetAKSClient creates an *AKSClient instance with the passed credentials, default logger and options. If credentials
+// is nil, they are resolved by the rest of the cluster.DefaultCredentialChain.
+func GetAKSClient(credentials *cluster.AKSCredential, options ...Option) (*AKSClient, error) {
+	return GetAKSClientWithProvider(cluster.DefaultCredentialChain(credentials), options...)
+}
+
+// GetAKSClientWithProvider creates an *AKSClient instance with the credentials resolved by the provider
+func GetAKSClientWithProvider(provider cluster.CredentialProvider, options ...Option) (*AKSClient, error) {
 
-	azureSdk, err := cluster.Authenticate(credentials)
+	azureSdk, err := cluster.AuthenticateWithProvider(provider)
 	if err != nil {
 		return nil, err
 	}
+	servicePrincipal := azureSdk.ServicePrincipal
 	aksClient := &AKSClient{
-		clientId: azureSdk.ServicePrincipal.ClientID,
-		secret:   azureSdk.ServicePrincipal.ClientSecret,
+		clientId: servicePrincipal.ClientID,
 		azureSdk: azureSdk,
-		logger:   getDefaultLogger(),
+		logger:   logging.NewLogrusLogger(getDefaultLogger()),
+	}
+	if identity := servicePrincipal.ClusterIdentity; identity != nil {
+		aksClient.clientId = identity.ClientId
+		aksClient.clusterIdentity = identity
+	}
+	// the other auth methods have no client ID or secret to fall back to, creating a cluster without a cluster
+	// identity fails with ErrManagementIdentity
+	if servicePrincipal.AuthMethod == cluster.AuthMethodClientSecret {
+		if aksClient.clientId == "" {
+			return nil, utils.NewErr("clientID is missing")
+		}
+		if servicePrincipal.ClientSecret == "" && servicePrincipal.SecretSource == nil && aksClient.clusterIdentity == nil {
+			return nil, utils.NewErr("secret is missing")
+		}
 	}
-	if aksClient.clientId == "" {
-		return nil, utils.NewErr("clientID is missing")
+	for _, option := range options {
+		option(aksClient)
 	}
-	if aksClient.secret == "" {
-		return nil, utils.NewErr("secret is missing")
+	// the retries wrap the rate limiter, so every attempt is charged to the budget
+	if aksClient.rateLimiter != nil {
+		azureSdk.DecorateSenders(aksClient.rateLimiter.SendDecorator())
+	}
+	if aksClient.retryPolicy != nil {
+		azureSdk.DecorateSenders(aksClient.retryPolicy.SendDecorator())
 	}
 	return aksClient, nil
 }
 
-// With sets logger
+// Close stops the background refresh of the client's token, see WithBackgroundTokenRefresh
+func (a *AKSClient) Close() {
+	a.azureSdk.Token.Stop()
+}
+
+// With sets logger, a logging.Logger or a *logrus.Logger.
+//
+// Deprecated: use the WithLogger option.
 func (a *AKSClient) With(i interface{}) {
 	if a != nil {
-		switch i.(type) {
-		case logrus.Logger:
-			logger := i.(logrus.Logger)
-			a.logger = &logger
+		switch logger := i.(type) {
+		case logging.Logger:
+			a.logger = logger
 		case *logrus.Logger:
-			a.logger = i.(*logrus.Logger)
+			a.logger = logging.NewLogrusLogger(logger)
 		}
 	}
 }
 
+// Logger returns the logger of the client. Its messages and fields are masked by the client's redactor and carry
+// the subscription of the client.
+func (a *AKSClient) Logger() logging.Logger {
+	logger := a.logger
+	if logger == nil {
+		logger = logging.NewNopLogger()
+	}
+	fields := logging.Fields{}
+	if a.azureSdk != nil {
+		fields[logging.FieldSubscription] = a.GetSubscriptionId()
+	}
+	return logging.NewRedactingLogger(logger, a.getRedactor()).With(fields)
+}
+
+// getRedactor returns the redactor every log message goes through
+func (a *AKSClient) getRedactor() *redact.Redactor {
+	if a.redactor == nil {
+		return redact.Default
+	}
+	return a.redactor
+}
+
 // getDefaultLogger return the default logger
 func getDefaultLogger() *logrus.Logger {
 	logger := logrus.New()
@@ -63,130 +134,225 @@ func getDefaultLogger() *logrus.Logger {
 	return logger
 }
 
+// WithContext returns a copy of the client whose calls to Azure carry ctx, e.g. to cancel them or to count their
+// retries with retry.WithCounter
+func (a *AKSClient) WithContext(ctx context.Context) *AKSClient {
+	c := *a
+	c.ctx = ctx
+	return &c
+}
+
+// requestContext returns the context of the calls to Azure, see WithContext
+func (a *AKSClient) requestContext() context.Context {
+	if a.ctx == nil {
+		return context.Background()
+	}
+	return a.ctx
+}
+
 func (a *AKSClient) List() ([]containerservice.ManagedCluster, error) {
-	page, err := a.azureSdk.ManagedClusterClient.List(context.Background())
+	page, err := a.azureSdk.ManagedClusterClient.List(a.requestContext())
 	if err != nil {
-		return nil, err
+		return nil, utils.ConvertError(err)
 	}
 	return page.Values(), nil
 }
 
 func (a *AKSClient) CreateOrUpdate(request *cluster.CreateClusterRequest, managedCluster *containerservice.ManagedCluster) (*containerservice.ManagedCluster, error) {
 
-	res, err := a.azureSdk.ManagedClusterClient.CreateOrUpdate(context.Background(), request.ResourceGroup, request.Name, *managedCluster)
+	res, err := a.azureSdk.ManagedClusterClient.CreateOrUpdate(a.requestContext(), request.ResourceGroup, request.Name, *managedCluster)
 	if err != nil {
-		return nil, err
+		return nil, utils.ConvertError(err)
 	}
 
 	mc, err := res.Result(*a.azureSdk.ManagedClusterClient)
 	if err != nil {
-		return nil, err
+		return nil, utils.ConvertError(err)
 	}
 
-	return &mc, err
+	return &mc, nil
 }
 
 func (a *AKSClient) Delete(resourceGroup, name string) (*http.Response, error) {
-	resp, err := a.azureSdk.ManagedClusterClient.Delete(context.Background(), resourceGroup, name)
+	resp, err := a.azureSdk.ManagedClusterClient.Delete(a.requestContext(), resourceGroup, name)
 	if err != nil {
-		return nil, err
+		return nil, utils.ConvertError(err)
 	}
 	return resp.Response(), nil
 }
 
 func (a *AKSClient) Get(resourceGroup, name string) (containerservice.ManagedCluster, error) {
-	return a.azureSdk.ManagedClusterClient.Get(context.Background(), resourceGroup, name)
+	managedCluster, err := a.azureSdk.ManagedClusterClient.Get(a.requestContext(), resourceGroup, name)
+	return managedCluster, utils.ConvertError(err)
 }
 
 func (a *AKSClient) GetAccessProfiles(resourceGroup, name, roleName string) (containerservice.ManagedClusterAccessProfile, error) {
-	return a.azureSdk.ManagedClusterClient.GetAccessProfiles(context.Background(), resourceGroup, name, roleName)
+	profile, err := a.azureSdk.ManagedClusterClient.GetAccessProfiles(a.requestContext(), resourceGroup, name, roleName)
+	return profile, utils.ConvertError(err)
 }
 
 func (a *AKSClient) ListVmSizes(location string) (result compute.VirtualMachineSizeListResult, err error) {
-	return a.azureSdk.VMSizeClient.List(context.Background(), location)
+	result, err = a.azureSdk.VMSizeClient.List(a.requestContext(), location)
+	return result, utils.ConvertError(err)
 }
 
 func (a *AKSClient) ListLocations() (subscriptions.LocationListResult, error) {
-	return a.azureSdk.SubscriptionsClient.ListLocations(context.Background(), a.azureSdk.ServicePrincipal.SubscriptionID)
+	locations, err := a.azureSdk.SubscriptionsClient.ListLocations(a.requestContext(), a.azureSdk.ServicePrincipal.SubscriptionID)
+	return locations, utils.ConvertError(err)
 }
 
 func (a *AKSClient) ListVersions(location, resourceType string) (result containerservice.OrchestratorVersionProfileListResult, err error) {
-	return a.azureSdk.ContainerServicesClient.ListOrchestrators(context.Background(), location, resourceType)
+	result, err = a.azureSdk.ContainerServicesClient.ListOrchestrators(a.requestContext(), location, resourceType)
+	return result, utils.ConvertError(err)
 }
 
-func (a *AKSClient) GetClientId() string {
-	return a.clientId
+// ListSubscriptions returns every subscription the credentials can see, with its state and display name
+func (a *AKSClient) ListSubscriptions() ([]subscriptions.Subscription, error) {
+	var result []subscriptions.Subscription
+	ctx := a.requestContext()
+	iterator, err := a.azureSdk.SubscriptionsClient.ListComplete(ctx)
+	for ; err == nil && iterator.NotDone(); err = iterator.Next() {
+		result = append(result, iterator.Value())
+	}
+	if err != nil {
+		return nil, utils.ConvertError(err)
+	}
+	return result, nil
 }
 
-func (a *AKSClient) GetClientSecret() string {
-	return a.secret
+// ListTenants returns every tenant the credentials can see
+func (a *AKSClient) ListTenants() ([]subscriptions.TenantIDDescription, error) {
+	var result []subscriptions.TenantIDDescription
+	ctx := a.requestContext()
+	iterator, err := a.azureSdk.TenantsClient.ListComplete(ctx)
+	for ; err == nil && iterator.NotDone(); err = iterator.Next() {
+		result = append(result, iterator.Value())
+	}
+	if err != nil {
+		return nil, utils.ConvertError(err)
+	}
+	return result, nil
 }
 
-func (a *AKSClient) LogDebug(args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Debug(args...)
+// ForSubscription returns a client of another subscription of the same tenant, e.g. one returned by
+// ListSubscriptions. It shares the token, logger and options of a, so it doesn't authenticate again. The calls are
+// charged to the subscription's own budget of the WithRateLimiter limiter.
+func (a *AKSClient) ForSubscription(subscriptionId string) *AKSClient {
+	return &AKSClient{
+		azureSdk:        a.azureSdk.ForSubscription(subscriptionId),
+		logger:          a.logger,
+		clientId:        a.clientId,
+		clusterIdentity: a.clusterIdentity,
+		redactor:        a.redactor,
+		auditTrail:      a.auditTrail,
+		hooks:           a.hooks,
+		locks:           a.locks,
+		retryPolicy:     a.retryPolicy,
+		rateLimiter:     a.rateLimiter,
+		ctx:             a.ctx,
 	}
 }
-func (a *AKSClient) LogInfo(args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Info(args...)
-	}
+
+// GetSubscriptionId returns the subscription the client manages clusters in
+func (a *AKSClient) GetSubscriptionId() string {
+	return a.azureSdk.ServicePrincipal.SubscriptionID
 }
-func (a *AKSClient) LogWarn(args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Warn(args...)
-	}
+
+// GetCredentialSource returns the name of the credential provider the client authenticated with
+func (a *AKSClient) GetCredentialSource() string {
+	return a.azureSdk.CredentialSource
 }
-func (a *AKSClient) LogError(args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Error(args...)
-	}
+
+// GetAuthMethod returns how the client authenticated against Azure Active Directory
+func (a *AKSClient) GetAuthMethod() cluster.AuthMethod {
+	return a.azureSdk.ServicePrincipal.AuthMethod
 }
 
-func (a *AKSClient) LogFatal(args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Fatal(args...)
+// WhoAmI returns the identity the client calls ARM with, decoded from its access token. Warnings, like a tenant
+// mismatch, are logged too.
+func (a *AKSClient) WhoAmI() (*cluster.Identity, error) {
+	identity, err := a.azureSdk.WhoAmI()
+	if err != nil {
+		return nil, utils.ConvertError(err)
+	}
+	for _, warning := range identity.Warnings {
+		a.Logger().Warn(warning, logging.Fields{logging.FieldOperation: "WhoAmI"})
 	}
+	return identity, nil
 }
 
-func (a *AKSClient) LogPanic(args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Panic(args...)
-	}
+// AuditTrail returns the trail the mutating operations are recorded in, or nil, see WithAuditTrail
+func (a *AKSClient) AuditTrail() *audit.Trail {
+	return a.auditTrail
 }
 
-func (a *AKSClient) LogDebugf(format string, args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Debugf(format, args...)
-	}
+// Hooks returns the lifecycle hooks of the client, or nil, see WithHooks
+func (a *AKSClient) Hooks() *hooks.Registry {
+	return a.hooks
 }
 
-func (a *AKSClient) LogInfof(format string, args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Infof(format, args...)
-	}
+// Locks returns the lock manager serializing the mutating operations of the client, or nil, see WithLocking
+func (a *AKSClient) Locks() *locking.Manager {
+	return a.locks
 }
 
-func (a *AKSClient) LogWarnf(format string, args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Warnf(format, args...)
+// AuditActor returns the identity the client calls ARM with. The object ID is decoded from the current access token
+// if there is one.
+func (a *AKSClient) AuditActor() audit.Actor {
+	servicePrincipal := a.azureSdk.ServicePrincipal
+	actor := audit.Actor{ClientId: servicePrincipal.ClientID, TenantId: servicePrincipal.TenantId}
+	if identity, err := cluster.DecodeIdentity(a.azureSdk.Token.OAuthToken()); err == nil {
+		actor.ObjectId = identity.ObjectId
+		if len(actor.TenantId) == 0 {
+			actor.TenantId = identity.TenantId
+		}
 	}
+	return actor
 }
 
-func (a *AKSClient) LogErrorf(format string, args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Errorf(format, args...)
-	}
+// GetBaseUrl returns the Resource Manager endpoint of the Azure cloud the client talks to
+func (a *AKSClient) GetBaseUrl() string {
+	return strings.TrimSuffix(a.azureSdk.Environment.ResourceManagerEndpoint, "/")
+}
+
+// GetEnvironment returns the Azure cloud the client talks to
+func (a *AKSClient) GetEnvironment() azure.Environment {
+	return a.azureSdk.Environment
+}
+
+// GetClusterIdentity returns the cluster identity set in the credentials, or nil if the client only has its
+// management credential
+func (a *AKSClient) GetClusterIdentity() *cluster.ClusterIdentity {
+	return a.clusterIdentity
+}
+
+// GetClientId returns the client ID of the service principal the created clusters run with
+func (a *AKSClient) GetClientId() string {
+	return a.clientId
 }
 
-func (a *AKSClient) LogFatalf(format string, args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Fatalf(format, args...)
+// GetClientSecret returns the secret of the service principal the created clusters run with. With a SecretSource in
+// the credentials, it's fetched from it on each call; failures are logged and return an empty string.
+func (a *AKSClient) GetClientSecret() string {
+	secret, err := a.clientSecret()
+	if err != nil {
+		a.Logger().Error("Failed to get client secret", logging.Fields{logging.FieldError: err.Error()})
 	}
+	return secret
 }
 
-func (a *AKSClient) LogPanicf(format string, args ...interface{}) {
-	if a.logger != nil {
-		a.logger.Panicf(format, args...)
+// clientSecret returns the secret of the cluster identity, or of the management credential without one
+func (a *AKSClient) clientSecret() (string, error) {
+	servicePrincipal := a.azureSdk.ServicePrincipal
+	name, secret := secrets.ClientSecret, servicePrincipal.ClientSecret
+	if identity := a.clusterIdentity; identity != nil {
+		if identity.KeyVaultSecret != nil {
+			return "", nil
+		}
+		name, secret = secrets.ClusterClientSecret, identity.ClientSecret
+	}
+	if len(secret) != 0 || servicePrincipal.SecretSource == nil {
+		return secret, nil
 	}
+	return servicePrincipal.SecretSource.Get(name)
 }
@@ -1,11 +1,25 @@
 package client
 
 import (
+	"bytes"
+	"context"
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/azure-aks-client/logging"
+	"github.com/banzaicloud/azure-aks-client/ratelimit"
+	"github.com/banzaicloud/azure-aks-client/recorder"
+	"github.com/banzaicloud/azure-aks-client/redact"
+	"github.com/banzaicloud/azure-aks-client/retry"
+	"github.com/banzaicloud/azure-aks-client/secrets"
 	"github.com/sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 const (
@@ -44,9 +58,8 @@ func TestGetAKSClient(t *testing.T) {
 			name:        "credentials from env",
 			credentials: nil,
 			expectedClient: &AKSClient{
-				logger:   getDefaultLogger(),
+				logger:   logging.NewLogrusLogger(getDefaultLogger()),
 				clientId: testClientId,
-				secret:   testClientSecret,
 			},
 			withDefaultLogger: true,
 		},
@@ -59,9 +72,8 @@ func TestGetAKSClient(t *testing.T) {
 				TenantId:       testTenantId,
 			},
 			expectedClient: &AKSClient{
-				logger:   getDefaultLogger(),
+				logger:   logging.NewLogrusLogger(getDefaultLogger()),
 				clientId: testClientId,
-				secret:   testClientSecret,
 			},
 			withDefaultLogger: true,
 		},
@@ -69,9 +81,8 @@ func TestGetAKSClient(t *testing.T) {
 			name:        "with custom logger",
 			credentials: nil,
 			expectedClient: &AKSClient{
-				logger:   getCustomLogger(),
+				logger:   logging.NewLogrusLogger(getCustomLogger()),
 				clientId: testClientId,
-				secret:   testClientSecret,
 			},
 			withDefaultLogger: false,
 		},
@@ -84,6 +95,8 @@ func TestGetAKSClient(t *testing.T) {
 				t.Error("Error during authenticate")
 				t.FailNow()
 			}
+			// GetAKSClient(nil) resolves the credentials from the environment
+			azureSdk.CredentialSource = cluster.ProviderEnvironment
 			tc.expectedClient.azureSdk = azureSdk
 
 			if cl, err := GetAKSClient(nil); err != nil {
@@ -102,9 +115,350 @@ func TestGetAKSClient(t *testing.T) {
 	}
 }
 
+func TestGetAKSClientWithProvider(t *testing.T) {
+	os.Setenv(cluster.AzureClientId, testClientId)
+	os.Setenv(cluster.AzureClientSecret, testClientSecret)
+	os.Setenv(cluster.AzureSubscriptionId, testSubscriptionId)
+	os.Setenv(cluster.AzureTenantId, testTenantId)
+
+	f, err := ioutil.TempFile("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"clientId":"fileClientId","clientSecret":"fileSecret","subscriptionId":"fileSubscriptionId","tenantId":"fileTenantId"}`)
+	f.Close()
+
+	explicit := &cluster.AKSCredential{
+		ClientId:       "explicitClientId",
+		ClientSecret:   testClientSecret,
+		SubscriptionId: testSubscriptionId,
+		TenantId:       testTenantId,
+	}
+	cases := []struct {
+		name        string
+		provider    cluster.CredentialProvider
+		expClientId string
+		expSource   string
+	}{
+		{name: "explicit before environment", provider: cluster.DefaultCredentialChain(explicit), expClientId: "explicitClientId", expSource: cluster.ProviderExplicit},
+		{name: "environment", provider: cluster.DefaultCredentialChain(nil), expClientId: testClientId, expSource: cluster.ProviderEnvironment},
+		{name: "auth file before environment", provider: cluster.NewChainProvider(&cluster.AuthFileProvider{Path: f.Name()}, &cluster.EnvProvider{}), expClientId: "fileClientId", expSource: cluster.ProviderAuthFile},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cl, err := GetAKSClientWithProvider(tc.provider)
+			if err != nil {
+				t.Fatalf("Error during get aks client %v", err)
+			}
+			if cl.GetClientId() != tc.expClientId || cl.GetCredentialSource() != tc.expSource {
+				t.Errorf("Expected client %s from %s, but got %s from %s", tc.expClientId, tc.expSource, cl.GetClientId(), cl.GetCredentialSource())
+			}
+		})
+	}
+}
+
 func getCustomLogger() *logrus.Logger {
 	log := logrus.New()
 	log.Level = logrus.ErrorLevel
 	log.Formatter = new(logrus.TextFormatter)
 	return log
 }
+
+func TestReplay(t *testing.T) {
+	f, err := ioutil.TempFile("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"interactions":[{
+		"request":{"method":"GET","url":"https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000000/providers/Microsoft.ContainerService/managedClusters?api-version=2017-08-31"},
+		"response":{"statusCode":200,"body":"{\"value\":[{\"name\":\"replayed\"}]}"}
+	}]}`)
+	f.Close()
+
+	rec, err := recorder.New(f.Name(), recorder.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		ClientId:       testClientId,
+		ClientSecret:   testClientSecret,
+		SubscriptionId: "00000000-0000-0000-0000-000000000000",
+		TenantId:       testTenantId,
+	}, WithRecorder(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusters, err := cl.List()
+	if err != nil {
+		t.Fatalf("Error during listing clusters: %s", err)
+	}
+	if len(clusters) != 1 || *clusters[0].Name != "replayed" {
+		t.Errorf("Expected the replayed cluster, but got %v", clusters)
+	}
+}
+
+func TestRetriesWrapRateLimiter(t *testing.T) {
+	statusCodes := []int{http.StatusTooManyRequests, http.StatusOK}
+	calls := 0
+	azure := func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			status := statusCodes[calls]
+			calls++
+			return &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(strings.NewReader(`{"value":[]}`)), Request: r}, nil
+		})
+	}
+	policy := retry.DefaultPolicy()
+	policy.Backoff = time.Millisecond
+	limiter := ratelimit.NewLimiter(3600, 3600)
+
+	// the options are passed in the opposite order of the decorators
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		ClientId:       testClientId,
+		ClientSecret:   testClientSecret,
+		SubscriptionId: testSubscriptionId,
+		TenantId:       testTenantId,
+	}, WithRetryPolicy(policy), WithRateLimiter(limiter), WithSendDecorators(azure))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cl.azureSdk.Clients() {
+		c.Authorizer = autorest.NullAuthorizer{}
+	}
+
+	var counter retry.Counter
+	if _, err := ListClusters(cl.WithContext(retry.WithCounter(context.Background(), &counter))); err != nil {
+		t.Fatalf("Error during listing clusters: %s", err)
+	}
+	if calls != 2 || counter.Retries() != 1 {
+		t.Errorf("Expected the throttled call retried once, but got %d calls, %d retries", calls, counter.Retries())
+	}
+	if reads := limiter.Subscription(testSubscriptionId).Remaining().Reads; reads != 3598 {
+		t.Errorf("Expected both attempts charged to the budget, but got %d reads left", reads)
+	}
+}
+
+func TestSubscriptionRateLimits(t *testing.T) {
+	// the first subscription has run out of reads
+	azure := func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			header := http.Header{}
+			if strings.Contains(r.URL.Path, testSubscriptionId) {
+				header.Set(ratelimit.RemainingReadsHeader, "0")
+			}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(strings.NewReader(`{"value":[]}`)), Request: r}, nil
+		})
+	}
+	limiter := ratelimit.NewLimiter(3600, 3600)
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		ClientId:       testClientId,
+		ClientSecret:   testClientSecret,
+		SubscriptionId: testSubscriptionId,
+		TenantId:       testTenantId,
+	}, WithRateLimiter(limiter), WithSendDecorators(azure))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range cl.azureSdk.Clients() {
+		c.Authorizer = autorest.NullAuthorizer{}
+	}
+	if _, err := ListClusters(cl); err != nil {
+		t.Fatalf("Error during listing clusters: %s", err)
+	}
+
+	other := cl.ForSubscription("11111111-1111-1111-1111-111111111111")
+	if other.rateLimiter != limiter {
+		t.Error("Expected the client of the other subscription to keep the limiter")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := ListClusters(other.WithContext(ctx)); err != nil {
+		t.Errorf("Expected the other subscription to have its own budget, but got %s", err)
+	}
+	if b := limiter.Subscription(testSubscriptionId).Remaining(); b.ServerReads != 0 {
+		t.Errorf("Expected the budget of %s to follow the response, but got %v", testSubscriptionId, b)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	f, err := ioutil.TempFile("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`{"interactions":[{
+		"request":{"method":"GET","url":"https://management.azure.com/subscriptions?api-version=2016-06-01"},
+		"response":{"statusCode":200,"body":"{\"value\":[{\"subscriptionId\":\"11111111-1111-1111-1111-111111111111\",\"displayName\":\"prod\",\"state\":\"Enabled\"},{\"subscriptionId\":\"22222222-2222-2222-2222-222222222222\",\"displayName\":\"old\",\"state\":\"Disabled\"}]}"}
+	},{
+		"request":{"method":"GET","url":"https://management.azure.com/tenants?api-version=2016-06-01"},
+		"response":{"statusCode":200,"body":"{\"value\":[{\"tenantId\":\"33333333-3333-3333-3333-333333333333\"}]}"}
+	},{
+		"request":{"method":"GET","url":"https://management.azure.com/subscriptions/00000000-0000-0000-0000-000000000000/providers/Microsoft.ContainerService/managedClusters?api-version=2017-08-31"},
+		"response":{"statusCode":200,"body":"{\"value\":[{\"name\":\"prodCluster\"}]}"}
+	}]}`)
+	f.Close()
+
+	rec, err := recorder.New(f.Name(), recorder.ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		ClientId:       testClientId,
+		ClientSecret:   testClientSecret,
+		SubscriptionId: testSubscriptionId,
+		TenantId:       testTenantId,
+	}, WithRecorder(rec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subs, err := cl.ListSubscriptions()
+	if err != nil {
+		t.Fatalf("Error during listing subscriptions: %s", err)
+	}
+	if len(subs) != 2 || *subs[0].DisplayName != "prod" || subs[1].State != "Disabled" {
+		t.Errorf("Unexpected subscriptions: %v", subs)
+	}
+	tenants, err := cl.ListTenants()
+	if err != nil {
+		t.Fatalf("Error during listing tenants: %s", err)
+	}
+	if len(tenants) != 1 || *tenants[0].TenantID != "33333333-3333-3333-3333-333333333333" {
+		t.Errorf("Unexpected tenants: %v", tenants)
+	}
+
+	prod := cl.ForSubscription(*subs[0].SubscriptionID)
+	if prod.GetSubscriptionId() != *subs[0].SubscriptionID || cl.GetSubscriptionId() != testSubscriptionId {
+		t.Errorf("Expected client of %s, but got %s", *subs[0].SubscriptionID, prod.GetSubscriptionId())
+	}
+	clusters, err := prod.List()
+	if err != nil {
+		t.Fatalf("Error during listing clusters: %s", err)
+	}
+	if len(clusters) != 1 || *clusters[0].Name != "prodCluster" {
+		t.Errorf("Expected the replayed cluster, but got %v", clusters)
+	}
+}
+
+func TestReadOnlyClientWithoutClusterIdentity(t *testing.T) {
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		AuthMethod:              cluster.AuthMethodManagedIdentity,
+		SubscriptionId:          testSubscriptionId,
+		ManagedIdentityEndpoint: "http://localhost/metadata/identity/oauth2/token",
+	})
+	if err != nil {
+		t.Fatalf("Expected a managed identity client without cluster identity, but got %s", err)
+	}
+	if _, err := resolveClusterIdentity(cl, &cluster.CreateClusterRequest{}); err != ErrManagementIdentity {
+		t.Errorf("Expected creating a cluster to fail with ErrManagementIdentity, but got %v", err)
+	}
+}
+
+func TestClusterSecretFromSource(t *testing.T) {
+	os.Setenv("TEST_CLUSTER_SECRET", "clusterSecret")
+	defer os.Unsetenv("TEST_CLUSTER_SECRET")
+
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		ClientId:        testClientId,
+		SubscriptionId:  testSubscriptionId,
+		TenantId:        testTenantId,
+		ClusterIdentity: &cluster.ClusterIdentity{ClientId: "clusterClientId"},
+		SecretSource: &secrets.EnvSource{Vars: map[string]string{
+			secrets.ClientSecret:        "TEST_MISSING_SECRET",
+			secrets.ClusterClientSecret: "TEST_CLUSTER_SECRET",
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := resolveClusterIdentity(cl, &cluster.CreateClusterRequest{})
+	if err != nil {
+		t.Fatalf("Error during resolving cluster identity: %s", err)
+	}
+	if identity.ClientId != "clusterClientId" || identity.ClientSecret != "clusterSecret" {
+		t.Errorf("Expected identity with sourced secret, but got %v", identity)
+	}
+	if cl.GetClusterIdentity().ClientSecret != "" {
+		t.Error("Expected the sourced secret not to be stored")
+	}
+
+	os.Unsetenv("TEST_CLUSTER_SECRET")
+	_, err = resolveClusterIdentity(cl, &cluster.CreateClusterRequest{})
+	if err == nil || !strings.Contains(err.Error(), (&secrets.NotFoundError{Name: secrets.ClusterClientSecret}).Error()) {
+		t.Errorf("Expected the error of the secret source, but got %v", err)
+	}
+}
+
+func TestSecretsFromSourceArentKept(t *testing.T) {
+	os.Setenv("TEST_CLUSTER_SECRET", "clusterSecret")
+	defer os.Unsetenv("TEST_CLUSTER_SECRET")
+
+	credentials := &cluster.AKSCredential{
+		ClientId:        testClientId,
+		ClientSecret:    testClientSecret,
+		SubscriptionId:  testSubscriptionId,
+		TenantId:        testTenantId,
+		ClusterIdentity: &cluster.ClusterIdentity{ClientId: "clusterClientId", ClientSecret: "explicitClusterSecret"},
+		SecretSource: &secrets.EnvSource{Vars: map[string]string{
+			secrets.ClusterClientSecret: "TEST_CLUSTER_SECRET",
+		}},
+	}
+	cl, err := GetAKSClient(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, sdk := range []*cluster.Sdk{cl.azureSdk, cl.azureSdk.ForSubscription("other")} {
+		servicePrincipal := sdk.ServicePrincipal
+		if servicePrincipal.ClientSecret != "" || servicePrincipal.HashMap[cluster.AzureClientSecret] != "" {
+			t.Errorf("Expected the explicit secret not to be kept, but got %+v", servicePrincipal)
+		}
+	}
+	if cl.GetClusterIdentity().ClientSecret != "" {
+		t.Error("Expected the explicit cluster secret not to be kept")
+	}
+	if secret := cl.GetClientSecret(); secret != "clusterSecret" {
+		t.Errorf("Expected the cluster secret fetched from the source, but got %q", secret)
+	}
+	if credentials.ClientSecret != testClientSecret || credentials.ClusterIdentity.ClientSecret != "explicitClusterSecret" {
+		t.Error("Expected the passed credentials unchanged")
+	}
+}
+
+func TestLogRedaction(t *testing.T) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+	logger.Level = logrus.DebugLevel
+	cl := &AKSClient{}
+	WithLogger(logging.NewLogrusLogger(logger))(cl)
+
+	logger.Formatter = &logrus.JSONFormatter{}
+	clientId, secret := "profileClientId", "topSecret"
+	profile := &containerservice.ServicePrincipalProfile{ClientID: &clientId, Secret: &secret}
+
+	// the profile holds the secret, unless masked
+	logging.NewLogrusLogger(logger).Debug("profile", logging.Fields{logging.FieldModel: profile})
+	if !strings.Contains(out.String(), secret) {
+		t.Fatalf("Expected the secret in the unmasked output, but got %s", out.String())
+	}
+	out.Reset()
+
+	cl.Logger().Debug("profile", logging.Fields{logging.FieldModel: profile})
+	cl.Logger().Info("token: abc.def", logging.Fields{"secret": secret})
+
+	if strings.Contains(out.String(), secret) || strings.Contains(out.String(), "abc.def") {
+		t.Errorf("Expected secrets masked, but got %s", out.String())
+	}
+	if !strings.Contains(out.String(), redact.Mask) || !strings.Contains(out.String(), clientId) {
+		t.Errorf("Expected masked output, but got %s", out.String())
+	}
+	if *profile.Secret != secret {
+		t.Error("Expected the logged value unchanged")
+	}
+}
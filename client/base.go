@@ -1,14 +1,23 @@
 package client
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2016-06-01/subscriptions"
+	autorestAzure "github.com/Azure/go-autorest/autorest/azure"
+	"github.com/banzaicloud/azure-aks-client/audit"
 	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/azure-aks-client/hooks"
+	"github.com/banzaicloud/azure-aks-client/locking"
+	"github.com/banzaicloud/azure-aks-client/logging"
+	"github.com/banzaicloud/azure-aks-client/utils"
 	"github.com/banzaicloud/banzai-types/components/azure"
 	"github.com/banzaicloud/banzai-types/constants"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -25,46 +34,363 @@ type ClusterManager interface {
 	GetClientId() string
 	GetClientSecret() string
 
-	LogDebug(args ...interface{})
-	LogInfo(args ...interface{})
-	LogWarn(args ...interface{})
-	LogError(args ...interface{})
-	LogFatal(args ...interface{})
-	LogPanic(args ...interface{})
-	LogDebugf(format string, args ...interface{})
-	LogInfof(format string, args ...interface{})
-	LogWarnf(format string, args ...interface{})
-	LogErrorf(format string, args ...interface{})
-	LogFatalf(format string, args ...interface{})
-	LogPanicf(format string, args ...interface{})
+	// Logger returns the logger of the operations, see package logging
+	Logger() logging.Logger
+}
+
+// ClusterIdentityProvider is implemented by the ClusterManagers that know a cluster identity separate from their
+// management credential, like *AKSClient
+type ClusterIdentityProvider interface {
+	GetClusterIdentity() *cluster.ClusterIdentity
+}
+
+// StatusUnchanged is the status code CreateUpdateCluster returns when the cluster already matches the request, so it
+// isn't sent to Azure
+const StatusUnchanged = http.StatusNotModified
+
+// Provisioning states of a managed cluster
+const (
+	stateFailed   = "Failed"
+	stateCanceled = "Canceled"
+	stateDeleting = "Deleting"
+)
+
+// ErrClusterDeleting is returned by CreateUpdateCluster when the cluster is being deleted
+var ErrClusterDeleting = errors.New("the cluster is being deleted")
+
+// SpecConflictError is returned by CreateUpdateCluster when the cluster exists with another spec and the request
+// doesn't allow updates
+type SpecConflictError struct {
+	ResourceGroup string
+	Name          string
+	Differences   []cluster.SpecDifference
+}
+
+func (e *SpecConflictError) Error() string {
+	var differences []string
+	for _, d := range e.Differences {
+		differences = append(differences, fmt.Sprintf("%s is %q, requested %q", d.Field, d.Existing, d.Requested))
+	}
+	return fmt.Sprintf("cluster %s in %s exists with another spec: %s", e.Name, e.ResourceGroup, strings.Join(differences, ", "))
+}
+
+// Auditor is implemented by the ClusterManagers that record their mutating operations in an audit trail, like
+// *AKSClient with the WithAuditTrail option
+type Auditor interface {
+	// AuditTrail returns the trail, or nil if the operations aren't recorded
+	AuditTrail() *audit.Trail
+	// AuditActor returns the identity the operations are made with
+	AuditActor() audit.Actor
+	GetSubscriptionId() string
+}
+
+// HookProvider is implemented by the ClusterManagers that run lifecycle hooks around their operations, like
+// *AKSClient with the WithHooks option
+type HookProvider interface {
+	Hooks() *hooks.Registry
+}
+
+// hooksOf returns the hooks of manager, or nil if it has none
+func hooksOf(manager ClusterManager) *hooks.Registry {
+	if provider, ok := manager.(HookProvider); ok {
+		return provider.Hooks()
+	}
+	return nil
+}
+
+// withHooks runs the operation between the pre- and post-hooks of op's event. A veto of a pre-hook is returned
+// without running the operation. Post-hooks receive the result or error of the operation, their failures are logged.
+func withHooks(registry *hooks.Registry, log logging.Logger, op *hooks.Operation, run func() (interface{}, error)) error {
+	if err := registry.RunPre(op); err != nil {
+		op.Err = logError(log, "Vetoed by pre-hook", err)
+	} else {
+		op.Result, op.Err = run()
+	}
+	// post-hooks don't change the result of the operation
+	err := op.Err
+	if postErr := registry.RunPost(op); postErr != nil {
+		log.Warn("Post-hooks failed", logging.Fields{logging.FieldError: postErr.Error()})
+	}
+	return err
+}
+
+// Locker is implemented by the ClusterManagers serializing the mutating operations on a cluster, like *AKSClient
+// with the WithLocking option
+type Locker interface {
+	// Locks returns the lock manager, or nil if the operations aren't serialized
+	Locks() *locking.Manager
+	GetSubscriptionId() string
+}
+
+// lockCluster acquires the lock of the cluster for operation if manager is a Locker, and returns the function
+// releasing it. Failing to release is logged.
+func lockCluster(manager ClusterManager, log logging.Logger, operation, resourceGroup, name string) (func(), error) {
+	locker, ok := manager.(Locker)
+	if !ok || locker.Locks() == nil {
+		return func() {}, nil
+	}
+	key := locking.Key(locker.GetSubscriptionId(), resourceGroup, name)
+	unlock, err := locker.Locks().Lock(context.Background(), key, operation)
+	if err != nil {
+		return nil, logError(log, "Locking the cluster failed", err)
+	}
+	return func() {
+		if err := unlock(); err != nil {
+			log.Error("Unlocking the cluster failed", logging.Fields{logging.FieldError: err.Error()})
+		}
+	}, nil
+}
+
+// ErrManagementIdentity is returned when a cluster would run with the management credential without opting in
+var ErrManagementIdentity = errors.New("refusing to use the management credential as the cluster's service principal: set the ClusterIdentity of the request or AllowManagementIdentity")
+
+// resolveClusterIdentity returns the service principal the cluster of the request runs with: the identity of the
+// request, then the one of the manager, then the management credential if the request allows it
+func resolveClusterIdentity(manager ClusterManager, request *cluster.CreateClusterRequest) (*cluster.ClusterIdentity, error) {
+	if request.ClusterIdentity != nil {
+		return request.ClusterIdentity, nil
+	}
+	if provider, ok := manager.(ClusterIdentityProvider); ok {
+		if identity := provider.GetClusterIdentity(); identity != nil {
+			if len(identity.ClientSecret) == 0 && identity.KeyVaultSecret == nil {
+				// the secret is kept in a secret source, it's only fetched for the request
+				secret, err := clientSecret(manager)
+				if err != nil {
+					return nil, fmt.Errorf("failed to get the secret of the cluster identity: %s", err)
+				}
+				resolved := *identity
+				resolved.ClientSecret = secret
+				identity = &resolved
+			}
+			return identity, identity.Validate()
+		}
+	}
+	if !request.AllowManagementIdentity {
+		return nil, ErrManagementIdentity
+	}
+	manager.Logger().Warn("The cluster runs with the management credential", logging.Fields{
+		logging.FieldOperation:     "CreateUpdateCluster",
+		logging.FieldResourceGroup: request.ResourceGroup,
+		logging.FieldCluster:       request.Name,
+	})
+	secret, err := clientSecret(manager)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the client secret: %s", err)
+	}
+	return &cluster.ClusterIdentity{
+		ClientId:     manager.GetClientId(),
+		ClientSecret: secret,
+	}, nil
+}
+
+// secretFetcher is implemented by the ClusterManagers that report why their client secret couldn't be fetched, like
+// *AKSClient with a secrets.SecretSource
+type secretFetcher interface {
+	clientSecret() (string, error)
+}
+
+// clientSecret returns the client secret of manager with the error of fetching it
+func clientSecret(manager ClusterManager) (string, error) {
+	if fetcher, ok := manager.(secretFetcher); ok {
+		return fetcher.clientSecret()
+	}
+	return manager.GetClientSecret(), nil
+}
+
+// operationLogger returns the logger of manager with the operation, resource group and cluster name fields. Empty
+// values are left out.
+func operationLogger(manager ClusterManager, operation, resourceGroup, name string) logging.Logger {
+	fields := logging.Fields{logging.FieldOperation: operation}
+	if len(resourceGroup) != 0 {
+		fields[logging.FieldResourceGroup] = resourceGroup
+	}
+	if len(name) != 0 {
+		fields[logging.FieldCluster] = name
+	}
+	return manager.Logger().With(fields)
+}
+
+// responseFields returns the ARM request ID of resp as log fields
+func responseFields(resp *http.Response) logging.Fields {
+	if resp == nil || len(resp.Header.Get(autorestAzure.HeaderRequestID)) == 0 {
+		return nil
+	}
+	return logging.Fields{logging.FieldRequestID: resp.Header.Get(autorestAzure.HeaderRequestID)}
+}
+
+// logError logs the failure of an operation with the ARM request ID of err, if it has one, and returns err
+func logError(log logging.Logger, msg string, err error) error {
+	fields := logging.Fields{logging.FieldError: err.Error()}
+	if aksErr, ok := err.(*utils.AKSError); ok && len(aksErr.RequestID) != 0 {
+		fields[logging.FieldRequestID] = aksErr.RequestID
+	}
+	log.Error(msg, fields)
+	return err
+}
+
+// audited runs the mutating operation and records it in the audit trail of manager, if it has one. The response of
+// run gives the status code and request ID of the record. Failing to record is logged, it doesn't fail the operation
+// that has already been made.
+func audited(manager ClusterManager, log logging.Logger, operation, resourceGroup, name string, request interface{}, run func() (*http.Response, error)) error {
+	start := time.Now()
+	resp, err := run()
+
+	auditor, ok := manager.(Auditor)
+	if !ok || auditor.AuditTrail() == nil {
+		return err
+	}
+	record := &audit.Record{
+		Operation: operation,
+		Actor:     auditor.AuditActor(),
+		Target: audit.Target{
+			SubscriptionId: auditor.GetSubscriptionId(),
+			ResourceGroup:  resourceGroup,
+			Name:           name,
+		},
+		Result:    audit.Result{Success: err == nil},
+		StartTime: start,
+		EndTime:   time.Now(),
+	}
+	if resp != nil {
+		record.Result.StatusCode = resp.StatusCode
+		record.Result.RequestID = resp.Header.Get(autorestAzure.HeaderRequestID)
+	}
+	if err != nil {
+		record.Result.Error = err.Error()
+		if aksErr, ok := err.(*utils.AKSError); ok {
+			record.Result.StatusCode = aksErr.StatusCode
+			record.Result.RequestID = aksErr.RequestID
+		}
+	}
+	if auditErr := auditor.AuditTrail().Record(record, request); auditErr != nil {
+		log.Error("Recording the operation in the audit trail failed", logging.Fields{logging.FieldError: auditErr.Error()})
+	}
+	return err
 }
 
 // CreateUpdateCluster creates or updates a managed cluster with the specified configuration for agents and Kubernetes
-// version.
+// version. It's idempotent: an existing cluster matching the request is returned with StatusUnchanged without
+// calling Azure, one with another spec fails with a *SpecConflictError unless the request sets AllowUpdate.
+// A cluster whose last operation failed or was canceled never matches, the request is sent again. A matching
+// cluster still being created or updated is returned with StatusUnchanged and its provisioning state, to be polled
+// with PollingCluster. A cluster being deleted fails with ErrClusterDeleting. The cluster is compared with the request
+// as the pre-hooks changed it.
 func CreateUpdateCluster(manager ClusterManager, request *cluster.CreateClusterRequest) (*azure.ResponseWithValue, error) {
 
 	if request == nil {
 		return nil, errors.New("Empty request")
 	}
 
-	manager.LogInfo("Start create/update cluster")
-	manager.LogDebugf("CreateRequest: %v", request)
-	manager.LogInfo("Validate cluster create/update request")
+	log := operationLogger(manager, "CreateUpdateCluster", request.ResourceGroup, request.Name)
+	log.Info("Start create/update cluster")
+	log.Debug("Create/update request", logging.Fields{logging.FieldRequest: request})
+	log.Info("Validate cluster create/update request")
 
 	if err := request.Validate(); err != nil {
-		return nil, err
+		return nil, logError(log, "Validate failed", err)
 	}
-	manager.LogInfo("Validate passed")
+	log.Info("Validate passed")
 
-	managedCluster := cluster.GetManagedCluster(request, manager.GetClientId(), manager.GetClientSecret())
-	manager.LogDebugf("Created managed cluster model - %#v", &managedCluster)
-	manager.LogDebug("Send request to azure")
-	result, err := manager.CreateOrUpdate(request, managedCluster)
+	unlock, err := lockCluster(manager, log, "CreateUpdateCluster", request.ResourceGroup, request.Name)
 	if err != nil {
 		return nil, err
 	}
+	defer unlock()
+
+	log.Debug("Get the existing cluster")
+	existing, err := manager.Get(request.ResourceGroup, request.Name)
+	if utils.IsNotFound(err) {
+		existing, err = containerservice.ManagedCluster{}, nil
+		log.Info("The cluster doesn't exist yet")
+	} else if err != nil {
+		return nil, logError(log, "Getting the existing cluster failed", err)
+	}
+	event := hooks.EventCreate
+	if existing.ID != nil {
+		if cluster.NewClusterInfo(&existing).ProvisioningState == stateDeleting {
+			return nil, logError(log, "The cluster is being deleted", ErrClusterDeleting)
+		}
+		event = updateEvent(cluster.SpecOf(&existing).Diff(request.Spec()))
+	}
 
-	manager.LogInfo("Create response model")
+	registry := hooksOf(manager)
+	if !registry.Has(event) {
+		return createUpdateExisting(manager, log, request, &existing)
+	}
+
+	// pre-hooks may change the request, the caller's one is left as it is. The existing cluster is compared with the
+	// changed request, so a request the hooks add to matches the cluster its earlier create made.
+	op := &hooks.Operation{Event: event, ResourceGroup: request.ResourceGroup, Name: request.Name, Request: request.DeepCopy()}
+	var response *azure.ResponseWithValue
+	err = withHooks(registry, log, op, func() (interface{}, error) {
+		if op.Request == nil {
+			return nil, errors.New("Empty request")
+		}
+		if err := op.Request.Validate(); err != nil {
+			return nil, logError(log, "Validate failed", err)
+		}
+		var err error
+		if response, err = createUpdateExisting(manager, log, op.Request, &existing); err != nil {
+			return nil, err
+		}
+		return response, nil
+	})
+	return response, err
+}
+
+// createUpdateExisting compares the existing cluster, empty if there's none, with the validated request and sends
+// the request unless the cluster matches it
+func createUpdateExisting(manager ClusterManager, log logging.Logger, request *cluster.CreateClusterRequest, existing *containerservice.ManagedCluster) (*azure.ResponseWithValue, error) {
+	if existing.ID == nil {
+		return createUpdateCluster(manager, log, request)
+	}
+	state := cluster.NewClusterInfo(existing).ProvisioningState
+	differences := cluster.SpecOf(existing).Diff(request.Spec())
+	failed := state == stateFailed || state == stateCanceled
+	if len(differences) == 0 && !failed {
+		log.Info(fmt.Sprintf("The cluster matches the request in state %s, it's left unchanged", state))
+		return &azure.ResponseWithValue{
+			StatusCode: StatusUnchanged,
+			Value:      *convertManagedClusterToValue(existing),
+		}, nil
+	}
+	if len(differences) != 0 && !request.AllowUpdate {
+		return nil, logError(log, "The cluster exists with another spec", &SpecConflictError{
+			ResourceGroup: request.ResourceGroup,
+			Name:          request.Name,
+			Differences:   differences,
+		})
+	}
+	if len(differences) == 0 {
+		log.Info(fmt.Sprintf("The last operation of the cluster ended %s, the request is sent again", state))
+	}
+	return createUpdateCluster(manager, log, request)
+}
+
+// createUpdateCluster sends the validated request
+func createUpdateCluster(manager ClusterManager, log logging.Logger, request *cluster.CreateClusterRequest) (*azure.ResponseWithValue, error) {
+	identity, err := resolveClusterIdentity(manager, request)
+	if err != nil {
+		return nil, logError(log, "Resolving cluster identity failed", err)
+	}
+	log.Info(fmt.Sprintf("The cluster runs with service principal %s", identity.ClientId))
+
+	managedCluster := cluster.NewManagedCluster(request, identity)
+	log.Debug("Created managed cluster model", logging.Fields{logging.FieldModel: managedCluster})
+	log.Debug("Send request to azure")
+	var result *containerservice.ManagedCluster
+	err = audited(manager, log, audit.OperationCreateUpdate, request.ResourceGroup, request.Name, request, func() (*http.Response, error) {
+		var err error
+		if result, err = manager.CreateOrUpdate(request, managedCluster); err != nil {
+			return nil, err
+		}
+		return result.Response.Response, nil
+	})
+	if err != nil {
+		return nil, logError(log, "Create/update cluster failed", err)
+	}
+
+	log.Info("Create response model", responseFields(result.Response.Response))
 
 	return &azure.ResponseWithValue{
 		StatusCode: result.Response.StatusCode,
@@ -73,61 +399,106 @@ func CreateUpdateCluster(manager ClusterManager, request *cluster.CreateClusterR
 
 }
 
+// updateEvent tells whether the differences of the request upgrade, scale or otherwise update the existing cluster
+func updateEvent(differences []cluster.SpecDifference) hooks.Event {
+	event := hooks.EventUpdate
+	for _, d := range differences {
+		switch d.Field {
+		case "KubernetesVersion":
+			return hooks.EventUpgrade
+		case "AgentCount":
+			event = hooks.EventScale
+		}
+	}
+	return event
+}
+
 // DeleteCluster deletes the managed cluster with a specified resource group and name.
 func DeleteCluster(manager ClusterManager, name string, resourceGroup string) error {
-	manager.LogInfof("Start deleting cluster %s in %s resource group", name, resourceGroup)
-	manager.LogDebug("Send request to azure")
+	log := operationLogger(manager, "DeleteCluster", resourceGroup, name)
+	log.Info("Start deleting cluster")
 
-	response, err := manager.Delete(resourceGroup, name)
+	unlock, err := lockCluster(manager, log, "DeleteCluster", resourceGroup, name)
 	if err != nil {
 		return err
 	}
+	defer unlock()
+
+	registry := hooksOf(manager)
+	if !registry.Has(hooks.EventDelete) {
+		return deleteCluster(manager, log, name, resourceGroup)
+	}
+	op := &hooks.Operation{Event: hooks.EventDelete, ResourceGroup: resourceGroup, Name: name}
+	return withHooks(registry, log, op, func() (interface{}, error) {
+		return nil, deleteCluster(manager, log, name, resourceGroup)
+	})
+}
+
+func deleteCluster(manager ClusterManager, log logging.Logger, name string, resourceGroup string) error {
+	log.Debug("Send request to azure")
 
-	manager.LogInfof("Status code: %d", response.StatusCode)
+	var response *http.Response
+	err := audited(manager, log, audit.OperationDelete, resourceGroup, name, nil, func() (*http.Response, error) {
+		var err error
+		response, err = manager.Delete(resourceGroup, name)
+		return response, err
+	})
+	if err != nil {
+		return logError(log, "Delete cluster failed", err)
+	}
+
+	log.Info(fmt.Sprintf("Status code: %d", response.StatusCode), responseFields(response))
 
 	return nil
 }
 
 // PollingCluster polls until the cluster ready or an error occurs
 func PollingCluster(manager ClusterManager, name string, resourceGroup string) (*azure.ResponseWithValue, error) {
+	return pollingCluster(context.Background(), manager, name, resourceGroup)
+}
+
+// pollingCluster polls until the cluster ready, an error occurs or ctx is done
+func pollingCluster(ctx context.Context, manager ClusterManager, name string, resourceGroup string) (*azure.ResponseWithValue, error) {
 	const stageSuccess = "Succeeded"
-	const stageFailed = "Failed"
 	const waitInSeconds = 10
 
-	manager.LogInfof("Start polling cluster: %s [%s]", name, resourceGroup)
+	log := operationLogger(manager, "PollingCluster", resourceGroup, name)
+	log.Info("Start polling cluster")
 
-	manager.LogDebug("Start loop")
+	log.Debug("Start loop")
 
 	result := azure.ResponseWithValue{}
 	for isReady := false; !isReady; {
 
-		manager.LogDebug("Send request to azure")
+		log.Debug("Send request to azure")
 		managedCluster, err := manager.Get(resourceGroup, name)
 		if err != nil {
-			return nil, err
+			return nil, logError(log, "Polling cluster failed", err)
 		}
 
 		statusCode := managedCluster.StatusCode
-		manager.LogInfof("Cluster polling status code: %d", statusCode)
-
-		convertManagedClusterToValue(&managedCluster)
+		log.Info(fmt.Sprintf("Cluster polling status code: %d", statusCode), responseFields(managedCluster.Response.Response))
 
 		switch statusCode {
 		case http.StatusOK:
 			response := convertManagedClusterToValue(&managedCluster)
 
-			stage := *managedCluster.ProvisioningState
-			manager.LogInfof("Cluster stage is %s", stage)
+			stage := response.Properties.ProvisioningState
+			log.Info(fmt.Sprintf("Cluster stage is %s", stage))
 
 			switch stage {
 			case stageSuccess:
 				isReady = true
 				result.Update(http.StatusCreated, *response)
-			case stageFailed:
+			case stateFailed:
 				return nil, constants.ErrorAzureCLusterStageFailed
 			default:
-				manager.LogInfo("Waiting for cluster ready...")
-				time.Sleep(waitInSeconds * time.Second)
+				log.Info("Waiting for cluster ready...")
+				select {
+				case <-time.After(waitInSeconds * time.Second):
+				case <-ctx.Done():
+					return nil, logError(log, "Polling cluster cancelled", ctx.Err())
+				}
 			}
 
 		default:
@@ -141,14 +512,15 @@ func PollingCluster(manager ClusterManager, name string, resourceGroup string) (
 // GetCluster gets the details of the managed cluster with a specified resource group and name.
 func GetCluster(manager ClusterManager, name string, resourceGroup string) (*azure.ResponseWithValue, error) {
 
-	manager.LogInfof("Start getting aks cluster: %s [%s]", name, resourceGroup)
+	log := operationLogger(manager, "GetCluster", resourceGroup, name)
+	log.Info("Start getting aks cluster")
 
 	managedCluster, err := manager.Get(resourceGroup, name)
 	if err != nil {
-		return nil, err
+		return nil, logError(log, "Get cluster failed", err)
 	}
 
-	manager.LogInfof("Status code: %d", managedCluster.StatusCode)
+	log.Info(fmt.Sprintf("Status code: %d", managedCluster.StatusCode), responseFields(managedCluster.Response.Response))
 
 	return &azure.ResponseWithValue{
 		StatusCode: managedCluster.StatusCode,
@@ -156,17 +528,69 @@ func GetCluster(manager ClusterManager, name string, resourceGroup string) (*azu
 	}, nil
 }
 
+// GetClusterInfo gets the details of the managed cluster with a specified resource group and name, including the
+// settings azure.Value leaves out
+func GetClusterInfo(manager ClusterManager, name string, resourceGroup string) (*cluster.ClusterInfo, error) {
+
+	log := operationLogger(manager, "GetClusterInfo", resourceGroup, name)
+	log.Info("Start getting aks cluster")
+
+	managedCluster, err := manager.Get(resourceGroup, name)
+	if err != nil {
+		return nil, logError(log, "Get cluster failed", err)
+	}
+
+	log.Info(fmt.Sprintf("Status code: %d", managedCluster.StatusCode), responseFields(managedCluster.Response.Response))
+	return cluster.NewClusterInfo(&managedCluster), nil
+}
+
+// ExportCluster exports the managed cluster into the request recreating it, see cluster.ExportCluster
+func ExportCluster(manager ClusterManager, name string, resourceGroup string) (*cluster.Export, error) {
+
+	log := operationLogger(manager, "ExportCluster", resourceGroup, name)
+	log.Info("Start exporting aks cluster")
+
+	managedCluster, err := manager.Get(resourceGroup, name)
+	if err != nil {
+		return nil, logError(log, "Get cluster failed", err)
+	}
+
+	export := cluster.ExportCluster(&managedCluster)
+	log.Info(fmt.Sprintf("Exported cluster, %d settings can't be reproduced", len(export.Unreproducible)),
+		responseFields(managedCluster.Response.Response))
+	return export, nil
+}
+
+// ListClusterInfos gets the details of the managed clusters in the specified subscription, including the settings
+// azure.Value leaves out
+func ListClusterInfos(manager ClusterManager) ([]*cluster.ClusterInfo, error) {
+	log := operationLogger(manager, "ListClusterInfos", "", "")
+	log.Info("Start listing clusters")
+
+	managedClusters, err := manager.List()
+	if err != nil {
+		return nil, logError(log, "List clusters failed", err)
+	}
+
+	var infos []*cluster.ClusterInfo
+	for i := range managedClusters {
+		infos = append(infos, cluster.NewClusterInfo(&managedClusters[i]))
+	}
+	return infos, nil
+}
+
 // ListClusters gets a list of managed clusters in the specified subscription. The operation returns properties of each managed
 // cluster.
 func ListClusters(manager ClusterManager) (*azure.ListResponse, error) {
-	manager.LogInfo("Start listing clusters")
+	log := operationLogger(manager, "ListClusters", "", "")
+	log.Info("Start listing clusters")
 
 	managedClusters, err := manager.List()
 	if err != nil {
-		return nil, err
+		return nil, logError(log, "List clusters failed", err)
 	}
 
-	manager.LogInfo("Create response model")
+	log.Info("Create response model")
 	response := azure.ListResponse{StatusCode: http.StatusOK, Value: azure.Values{
 		Value: convertManagedClustersToValues(managedClusters),
 	}}
@@ -176,16 +600,34 @@ func ListClusters(manager ClusterManager) (*azure.ListResponse, error) {
 // GetClusterConfig gets the given cluster kubeconfig
 func GetClusterConfig(manager ClusterManager, name, resourceGroup, roleName string) (*azure.Config, error) {
 
-	manager.LogInfof("Start getting %s cluster's config in %s, role name: %s", name, resourceGroup, roleName)
+	log := operationLogger(manager, "GetClusterConfig", resourceGroup, name)
+	log.Info(fmt.Sprintf("Start getting cluster's config, role name: %s", roleName))
 
-	manager.LogDebug("Send request to azure")
+	registry := hooksOf(manager)
+	if !registry.Has(hooks.EventGetConfig) {
+		return getClusterConfig(manager, log, name, resourceGroup, roleName)
+	}
+	op := &hooks.Operation{Event: hooks.EventGetConfig, ResourceGroup: resourceGroup, Name: name}
+	var config *azure.Config
+	err := withHooks(registry, log, op, func() (interface{}, error) {
+		var err error
+		if config, err = getClusterConfig(manager, log, name, resourceGroup, roleName); err != nil {
+			return nil, err
+		}
+		return config, nil
+	})
+	return config, err
+}
+
+func getClusterConfig(manager ClusterManager, log logging.Logger, name, resourceGroup, roleName string) (*azure.Config, error) {
+	log.Debug("Send request to azure")
 	profile, err := manager.GetAccessProfiles(resourceGroup, name, roleName)
 	if err != nil {
-		return nil, err
+		return nil, logError(log, "Get cluster config failed", err)
 	}
 
-	manager.LogInfof("Status code: %d", profile.StatusCode)
-	manager.LogInfo("Create response model")
+	log.Info(fmt.Sprintf("Status code: %d", profile.StatusCode), responseFields(profile.Response.Response))
+	log.Info("Create response model")
 	return &azure.Config{
 		Location: *profile.Location,
 		Name:     *profile.Name,
@@ -200,11 +642,13 @@ func GetClusterConfig(manager ClusterManager, name, resourceGroup, roleName stri
 // GetLocations returns all the locations that are available for resource providers
 func GetLocations(manager ClusterManager) ([]string, error) {
 
-	manager.LogInfo("Start listing locations")
+	log := operationLogger(manager, "GetLocations", "", "")
+	log.Info("Start listing locations")
 	resp, err := manager.ListLocations()
 	if err != nil {
-		return nil, err
+		return nil, logError(log, "List locations failed", err)
 	}
+	log.Debug("Create response model", responseFields(resp.Response.Response))
 
 	var locations []string
 	for _, loc := range *resp.Value {
@@ -217,11 +661,13 @@ func GetLocations(manager ClusterManager) ([]string, error) {
 // GetVmSizes lists all available virtual machine sizes for a subscription in a location.
 func GetVmSizes(manager ClusterManager, location string) ([]string, error) {
 
-	manager.LogInfo("Start listing vm sizes")
+	log := operationLogger(manager, "GetVmSizes", "", "")
+	log.Info("Start listing vm sizes")
 	resp, err := manager.ListVmSizes(location)
 	if err != nil {
-		return nil, err
+		return nil, logError(log, "List vm sizes failed", err)
 	}
+	log.Debug("Create response model", responseFields(resp.Response.Response))
 
 	var sizes []string
 	for _, vm := range *resp.Value {
@@ -233,11 +679,13 @@ func GetVmSizes(manager ClusterManager, location string) ([]string, error) {
 // GetKubernetesVersions returns a list of supported kubernetes version in the specified subscription
 func GetKubernetesVersions(manager ClusterManager, location string) ([]string, error) {
 
-	manager.LogInfo("Start listing Kubernetes versions")
+	log := operationLogger(manager, "GetKubernetesVersions", "", "")
+	log.Info("Start listing Kubernetes versions")
 	resp, err := manager.ListVersions(location, string(compute.Kubernetes))
 	if err != nil {
-		return nil, err
+		return nil, logError(log, "List Kubernetes versions failed", err)
 	}
+	log.Debug("Create response model", responseFields(resp.Response.Response))
 
 	var versions []string
 	for _, v := range *resp.OrchestratorVersionProfileProperties.Orchestrators {
@@ -256,27 +704,8 @@ func convertManagedClustersToValues(managedCluster []containerservice.ManagedClu
 	return values
 }
 
-// convertManagedClusterToValue returns Value with the ManagedCluster properties
+// convertManagedClusterToValue returns Value with the ManagedCluster properties, see cluster.ClusterInfo
 func convertManagedClusterToValue(managedCluster *containerservice.ManagedCluster) *azure.Value {
-
-	var profiles []azure.Profile
-	if managedCluster.AgentPoolProfiles != nil {
-		for _, p := range *managedCluster.AgentPoolProfiles {
-			profiles = append(profiles, azure.Profile{
-				Name:  *p.Name,
-				Count: int(*p.Count),
-			})
-		}
-	}
-
-	return &azure.Value{
-		Id:       *managedCluster.ID,
-		Location: *managedCluster.Location,
-		Name:     *managedCluster.Name,
-		Properties: azure.Properties{
-			ProvisioningState: *managedCluster.ProvisioningState,
-			AgentPoolProfiles: profiles,
-			Fqdn:              *managedCluster.Fqdn,
-		},
-	}
+	value := cluster.NewClusterInfo(managedCluster).ToValue()
+	return &value
 }
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/azure-aks-client/logging"
+	"github.com/banzaicloud/banzai-types/components/azure"
+)
+
+// DefaultBulkParallelism is the number of clusters a bulk operation works on at once by default
+const DefaultBulkParallelism = 4
+
+// BulkOptions configure a bulk operation
+type BulkOptions struct {
+	// Parallelism is the number of clusters worked on at once, DefaultBulkParallelism if less than 1
+	Parallelism int
+	// FailFast skips the clusters not started yet after the first failure. The clusters already in progress, e.g.
+	// waited for with Wait, are finished. Without it, a failure doesn't affect the other clusters.
+	FailFast bool
+	// Wait polls the created clusters until they are ready, see PollingCluster
+	Wait bool
+}
+
+func (o BulkOptions) parallelism() int {
+	if o.Parallelism < 1 {
+		return DefaultBulkParallelism
+	}
+	return o.Parallelism
+}
+
+// ClusterTarget identifies a cluster of a bulk operation
+type ClusterTarget struct {
+	ResourceGroup string `json:"resourceGroup"`
+	Name          string `json:"name"`
+}
+
+func (t ClusterTarget) String() string {
+	return t.ResourceGroup + "/" + t.Name
+}
+
+// BulkResult is the outcome of a bulk operation for a cluster. Response is nil for deletes and failures.
+type BulkResult struct {
+	Target   ClusterTarget
+	Response *azure.ResponseWithValue
+	Err      error
+}
+
+// BulkError is returned by the bulk operations if any of the clusters failed. Clusters that were not started
+// because ctx was done or an earlier cluster failed with FailFast fail with the error of the context.
+type BulkError struct {
+	Total  int
+	Failed []BulkResult
+}
+
+func (e *BulkError) Error() string {
+	var messages []string
+	for _, r := range e.Failed {
+		messages = append(messages, fmt.Sprintf("%s: %s", r.Target, r.Err))
+	}
+	return fmt.Sprintf("%d of %d cluster operations failed: %s", len(e.Failed), e.Total, strings.Join(messages, "; "))
+}
+
+// BulkCreate creates or updates the clusters of the requests concurrently, see CreateUpdateCluster. The results are
+// in the order of the requests.
+func BulkCreate(ctx context.Context, manager ClusterManager, requests []*cluster.CreateClusterRequest, opts BulkOptions) ([]BulkResult, error) {
+	targets := make([]ClusterTarget, len(requests))
+	for i, request := range requests {
+		if request != nil {
+			targets[i] = ClusterTarget{ResourceGroup: request.ResourceGroup, Name: request.Name}
+		}
+	}
+	return runBulk(ctx, manager, "BulkCreate", targets, opts, func(ctx context.Context, i int) (*azure.ResponseWithValue, error) {
+		response, err := CreateUpdateCluster(manager, requests[i])
+		if err != nil || !opts.Wait {
+			return response, err
+		}
+		return pollingCluster(ctx, manager, targets[i].Name, targets[i].ResourceGroup)
+	})
+}
+
+// BulkDelete deletes the clusters concurrently, see DeleteCluster. The results are in the order of the targets.
+func BulkDelete(ctx context.Context, manager ClusterManager, targets []ClusterTarget, opts BulkOptions) ([]BulkResult, error) {
+	return runBulk(ctx, manager, "BulkDelete", targets, opts, func(ctx context.Context, i int) (*azure.ResponseWithValue, error) {
+		return nil, DeleteCluster(manager, targets[i].Name, targets[i].ResourceGroup)
+	})
+}
+
+// BulkGet gets the details of the clusters concurrently, see GetCluster. The results are in the order of the
+// targets.
+func BulkGet(ctx context.Context, manager ClusterManager, targets []ClusterTarget, opts BulkOptions) ([]BulkResult, error) {
+	return runBulk(ctx, manager, "BulkGet", targets, opts, func(ctx context.Context, i int) (*azure.ResponseWithValue, error) {
+		return GetCluster(manager, targets[i].Name, targets[i].ResourceGroup)
+	})
+}
+
+// runBulk runs the operation for every target with bounded concurrency, starting them in order, and aggregates the
+// failures in a *BulkError
+func runBulk(ctx context.Context, manager ClusterManager, operation string, targets []ClusterTarget, opts BulkOptions,
+	run func(ctx context.Context, i int) (*azure.ResponseWithValue, error)) ([]BulkResult, error) {
+
+	log := manager.Logger().With(logging.Fields{logging.FieldOperation: operation})
+	log.Info(fmt.Sprintf("Start %d cluster operations", len(targets)))
+
+	// stopping the dispatch with FailFast doesn't cancel ctx, so the clusters in progress are finished
+	dispatch, stop := context.WithCancel(ctx)
+	defer stop()
+
+	results := make([]BulkResult, len(targets))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < opts.parallelism(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i].Response, results[i].Err = runBulkItem(ctx, dispatch, i, run)
+				if results[i].Err != nil && opts.FailFast {
+					stop()
+				}
+			}
+		}()
+	}
+	for i := range targets {
+		results[i].Target = targets[i]
+		if err := dispatch.Err(); err != nil {
+			results[i].Err = err
+			continue
+		}
+		select {
+		case indexes <- i:
+		case <-dispatch.Done():
+			results[i].Err = dispatch.Err()
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	bulkErr := &BulkError{Total: len(targets)}
+	for _, r := range results {
+		if r.Err != nil {
+			bulkErr.Failed = append(bulkErr.Failed, r)
+		}
+	}
+	if len(bulkErr.Failed) != 0 {
+		log.Warn(fmt.Sprintf("%d of %d cluster operations failed", len(bulkErr.Failed), len(targets)))
+		return results, bulkErr
+	}
+	log.Info(fmt.Sprintf("Finished %d cluster operations", len(targets)))
+	return results, nil
+}
+
+// runBulkItem runs the operation of a target with ctx unless the dispatch is stopped
+func runBulkItem(ctx, dispatch context.Context, i int, run func(ctx context.Context, i int) (*azure.ResponseWithValue, error)) (*azure.ResponseWithValue, error) {
+	// the target may be received together with a stopped dispatch
+	if err := dispatch.Err(); err != nil {
+		return nil, err
+	}
+	return run(ctx, i)
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/banzai-types/components/azure"
+)
+
+func TestFailFastFinishesStarted(t *testing.T) {
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		ClientId:       testClientId,
+		ClientSecret:   testClientSecret,
+		SubscriptionId: testSubscriptionId,
+		TenantId:       testTenantId,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first cluster is still in progress when the second one fails
+	failed := make(chan struct{})
+	targets := []ClusterTarget{{Name: "inProgress"}, {Name: "broken"}, {Name: "skipped"}}
+	results, err := runBulk(context.Background(), cl, "BulkTest", targets, BulkOptions{Parallelism: 2, FailFast: true},
+		func(ctx context.Context, i int) (*azure.ResponseWithValue, error) {
+			switch i {
+			case 0:
+				<-failed
+				time.Sleep(50 * time.Millisecond)
+				return &azure.ResponseWithValue{}, ctx.Err()
+			case 1:
+				close(failed)
+				return nil, errors.New("no access")
+			}
+			return &azure.ResponseWithValue{}, nil
+		})
+
+	bulkErr, ok := err.(*BulkError)
+	if !ok || len(bulkErr.Failed) != 2 {
+		t.Fatalf("Expected the failed and the skipped cluster, but got %v", err)
+	}
+	if results[0].Err != nil || results[0].Response == nil {
+		t.Errorf("Expected the cluster in progress to be finished, but got %v", results[0].Err)
+	}
+	if results[2].Err != context.Canceled {
+		t.Errorf("Expected the last cluster to be skipped, but got %v", results[2].Err)
+	}
+}
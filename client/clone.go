@@ -0,0 +1,209 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/banzai-types/components/azure"
+)
+
+// CloneTag is the tag of a clone holding the ID of the cluster it was cloned from
+const CloneTag = "clonedFrom"
+
+// maxDNSPrefixLength is the longest DNS prefix AKS accepts
+const maxDNSPrefixLength = 54
+
+// CloneOptions configure a clone. The empty settings of the target are the ones of the source.
+type CloneOptions struct {
+	// Name, ResourceGroup and Location of the clone
+	Name          string
+	ResourceGroup string
+	Location      string
+	// DNSPrefix of the clone. If empty, the name of the source in its DNS prefix is replaced with the name of the
+	// clone, or the name of the clone is appended if the prefix doesn't hold it.
+	DNSPrefix string
+	// VnetSubnetId of the clone. If empty, the subnet of the source is only reused with ReuseSubnet, e.g. so a
+	// disaster recovery drill doesn't land in the production subnet.
+	VnetSubnetId string
+	// ReuseSubnet places the clone in the subnet of the source, only in its location as a virtual network can't span
+	// locations
+	ReuseSubnet bool
+	// ClusterIdentity of the clone. If nil, a Key Vault reference of the source is reused, otherwise the clone runs
+	// with the cluster identity of the client, as the secret of the source isn't exported.
+	ClusterIdentity *cluster.ClusterIdentity
+	// DryRun returns the plan without creating the clone
+	DryRun bool
+	// Wait polls the clone until it's ready, see PollingCluster
+	Wait bool
+}
+
+// ClonePlan is the request creating the clone of a cluster
+type ClonePlan struct {
+	Source  ClusterTarget                 `json:"source"`
+	Request *cluster.CreateClusterRequest `json:"request"`
+	// Unreproducible lists the settings of the source the clone doesn't have, see cluster.ExportCluster
+	Unreproducible []cluster.UnreproducibleField `json:"unreproducible,omitempty"`
+	// Problems prevent the clone from being created
+	Problems []string `json:"problems,omitempty"`
+}
+
+// CloneError is returned when the clone of a cluster can't be created as planned
+type CloneError struct {
+	Source   ClusterTarget
+	Problems []string
+}
+
+func (e *CloneError) Error() string {
+	return fmt.Sprintf("cloning cluster %s failed: %s", e.Source, strings.Join(e.Problems, "; "))
+}
+
+// PlanClone returns the plan of cloning the source cluster, without creating anything. The problems of the plan,
+// like a VM size or Kubernetes version unavailable in the target location, are listed in the plan.
+func PlanClone(manager ClusterManager, source ClusterTarget, opts CloneOptions) (*ClonePlan, error) {
+
+	if len(source.ResourceGroup) == 0 || len(source.Name) == 0 {
+		return nil, errors.New("Empty clone source")
+	}
+
+	log := operationLogger(manager, "PlanClone", source.ResourceGroup, source.Name)
+	log.Info("Start planning the clone of aks cluster")
+
+	managedCluster, err := manager.Get(source.ResourceGroup, source.Name)
+	if err != nil {
+		return nil, logError(log, "Get cluster failed", err)
+	}
+	export := cluster.ExportCluster(&managedCluster)
+	plan := &ClonePlan{Source: source, Request: export.Request, Unreproducible: export.Unreproducible}
+	request := plan.Request
+	problem := func(format string, args ...interface{}) {
+		plan.Problems = append(plan.Problems, fmt.Sprintf(format, args...))
+	}
+
+	request.Name, request.ResourceGroup = source.Name, source.ResourceGroup
+	sourceName, sourceLocation := request.Name, request.Location
+	if len(opts.Name) != 0 {
+		request.Name = opts.Name
+	}
+	if len(opts.ResourceGroup) != 0 {
+		request.ResourceGroup = opts.ResourceGroup
+	}
+	if len(opts.Location) != 0 {
+		request.Location = opts.Location
+	}
+	if strings.EqualFold(request.Name, sourceName) && strings.EqualFold(request.ResourceGroup, source.ResourceGroup) {
+		problem("the clone needs another name or resource group than the source")
+	}
+
+	if len(opts.DNSPrefix) != 0 {
+		request.DNSPrefix = opts.DNSPrefix
+	} else {
+		request.DNSPrefix = cloneDNSPrefix(request.DNSPrefix, sourceName, request.Name)
+	}
+	if len(request.DNSPrefix) > maxDNSPrefixLength {
+		problem("DNS prefix %q is longer than %d characters", request.DNSPrefix, maxDNSPrefixLength)
+	}
+
+	sameLocation := cluster.NormalizeLocation(request.Location) == cluster.NormalizeLocation(sourceLocation)
+	if len(opts.VnetSubnetId) != 0 {
+		request.VnetSubnetId = opts.VnetSubnetId
+	} else if len(request.VnetSubnetId) != 0 && !sameLocation {
+		problem("subnet %s is in %s, set the subnet of the clone in %s", request.VnetSubnetId, sourceLocation, request.Location)
+	} else if len(request.VnetSubnetId) != 0 && !opts.ReuseSubnet {
+		problem("the clone would share subnet %s with the source, set the subnet of the clone or opt into reusing it", request.VnetSubnetId)
+	}
+
+	if opts.ClusterIdentity != nil {
+		request.ClusterIdentity = opts.ClusterIdentity
+	} else if identity := request.ClusterIdentity; identity != nil && identity.ClientSecret == cluster.SecretPlaceholder {
+		request.ClusterIdentity = nil
+	}
+	if err := request.Validate(); err != nil {
+		problem("invalid request: %s", err)
+	}
+	// the create fails the same way, e.g. with ErrManagementIdentity
+	if _, err := resolveClusterIdentity(manager, request); err != nil {
+		problem("cluster identity: %s", err)
+	}
+
+	tags := make(map[string]string, len(request.Tags)+1)
+	for k, v := range request.Tags {
+		tags[k] = v
+	}
+	if managedCluster.ID != nil {
+		tags[CloneTag] = *managedCluster.ID
+	}
+	request.Tags = tags
+
+	if !sameLocation {
+		log.Info(fmt.Sprintf("Check availability in %s", request.Location))
+	}
+	sizes, err := GetVmSizes(manager, request.Location)
+	if err != nil {
+		return nil, logError(log, "Checking VM sizes failed", err)
+	}
+	if !containsFold(sizes, request.VMSize) {
+		problem("VM size %s isn't available in %s", request.VMSize, request.Location)
+	}
+	versions, err := GetKubernetesVersions(manager, request.Location)
+	if err != nil {
+		return nil, logError(log, "Checking Kubernetes versions failed", err)
+	}
+	if !containsFold(versions, request.KubernetesVersion) {
+		problem("Kubernetes version %s isn't available in %s", request.KubernetesVersion, request.Location)
+	}
+
+	log.Info(fmt.Sprintf("Planned clone %s/%s in %s, %d problems", request.ResourceGroup, request.Name, request.Location, len(plan.Problems)))
+	return plan, nil
+}
+
+// CloneCluster creates a cluster equivalent to the source under another name, resource group or location, e.g. for
+// disaster recovery drills. A plan with problems fails with a *CloneError. With DryRun, only the plan is returned.
+// The clone is created with CreateUpdateCluster, so an existing clone matching the plan is left unchanged.
+func CloneCluster(ctx context.Context, manager ClusterManager, source ClusterTarget, opts CloneOptions) (*ClonePlan, *azure.ResponseWithValue, error) {
+	plan, err := PlanClone(manager, source, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(plan.Problems) != 0 {
+		return plan, nil, &CloneError{Source: source, Problems: plan.Problems}
+	}
+	if opts.DryRun {
+		return plan, nil, nil
+	}
+
+	request := *plan.Request
+	response, err := CreateUpdateCluster(manager, &request)
+	if err != nil || !opts.Wait {
+		return plan, response, err
+	}
+	response, err = pollingCluster(ctx, manager, request.Name, request.ResourceGroup)
+	return plan, response, err
+}
+
+// cloneDNSPrefix returns the DNS prefix of a clone named target of the source cluster with prefix
+func cloneDNSPrefix(prefix, source, target string) string {
+	if strings.EqualFold(source, target) {
+		return prefix
+	}
+	// cluster names may hold underscores, DNS names can't
+	target = strings.Replace(target, "_", "-", -1)
+	if len(source) != 0 && strings.Contains(prefix, source) {
+		return strings.Replace(prefix, source, target, -1)
+	}
+	if len(prefix) == 0 {
+		return target
+	}
+	return prefix + "-" + target
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2016-06-01/subscriptions"
+	"github.com/banzaicloud/azure-aks-client/logging"
+	"github.com/banzaicloud/banzai-types/components/azure"
+)
+
+// DefaultFleetParallelism is the number of subscriptions a FleetClient lists at once by default
+const DefaultFleetParallelism = 4
+
+// FleetClient manages the clusters of several subscriptions of a tenant through one authenticated *AKSClient
+type FleetClient struct {
+	client *AKSClient
+	// SubscriptionIds are the subscriptions of the fleet, if empty every enabled subscription the credentials can
+	// see is used
+	SubscriptionIds []string
+	// Parallelism bounds the number of subscriptions called at once
+	Parallelism int
+}
+
+// NewFleetClient creates a FleetClient of the subscriptions, or of every discovered one if none is passed
+func NewFleetClient(client *AKSClient, subscriptionIds ...string) *FleetClient {
+	return &FleetClient{
+		client:          client,
+		SubscriptionIds: subscriptionIds,
+		Parallelism:     DefaultFleetParallelism,
+	}
+}
+
+// FleetCluster is a cluster of the fleet tagged with its subscription
+type FleetCluster struct {
+	SubscriptionId string `json:"subscriptionId"`
+	azure.Value
+}
+
+// SubscriptionError is the failure of a subscription of the fleet
+type SubscriptionError struct {
+	SubscriptionId string
+	Err            error
+}
+
+func (e SubscriptionError) Error() string {
+	return fmt.Sprintf("subscription %s: %s", e.SubscriptionId, e.Err)
+}
+
+// FleetListResponse is the result of FleetClient.ListClusters. Clusters are ordered by subscription, the
+// subscriptions that failed are in Errors.
+type FleetListResponse struct {
+	Clusters []FleetCluster      `json:"clusters"`
+	Errors   []SubscriptionError `json:"-"`
+}
+
+// Subscriptions returns the subscriptions of the fleet, discovering them if SubscriptionIds is empty
+func (f *FleetClient) Subscriptions() ([]string, error) {
+	if len(f.SubscriptionIds) != 0 {
+		return f.SubscriptionIds, nil
+	}
+	subs, err := f.client.ListSubscriptions()
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, s := range subs {
+		if s.SubscriptionID != nil && (s.State == subscriptions.Enabled || s.State == subscriptions.Warned) {
+			ids = append(ids, *s.SubscriptionID)
+		}
+	}
+	return ids, nil
+}
+
+// ListClusters lists the clusters of every subscription concurrently. The failure of a subscription doesn't fail
+// the listing, it's reported in the Errors of the response; only the discovery of the subscriptions does.
+func (f *FleetClient) ListClusters() (*FleetListResponse, error) {
+	ids, err := f.Subscriptions()
+	if err != nil {
+		return nil, err
+	}
+	log := f.client.Logger().With(logging.Fields{logging.FieldOperation: "ListFleetClusters"})
+	log.Info(fmt.Sprintf("Start listing clusters of %d subscriptions", len(ids)))
+
+	parallelism := f.Parallelism
+	if parallelism < 1 {
+		parallelism = DefaultFleetParallelism
+	}
+
+	results := make([]*azure.ListResponse, len(ids))
+	errs := make([]error, len(ids))
+	semaphore := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			results[i], errs[i] = ListClusters(f.client.ForSubscription(id))
+		}(i, id)
+	}
+	wg.Wait()
+
+	response := &FleetListResponse{}
+	for i, id := range ids {
+		if errs[i] != nil {
+			log.Warn("Listing clusters of subscription failed", logging.Fields{
+				logging.FieldSubscription: id,
+				logging.FieldError:        errs[i].Error(),
+			})
+			response.Errors = append(response.Errors, SubscriptionError{SubscriptionId: id, Err: errs[i]})
+			continue
+		}
+		for _, value := range results[i].Value.Value {
+			response.Clusters = append(response.Clusters, FleetCluster{SubscriptionId: id, Value: value})
+		}
+	}
+	return response, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/banzaicloud/azure-aks-client/cluster"
+)
+
+const testCluster = `{"id":"/subscriptions/%[1]s/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/%[2]s","name":"%[2]s","location":"westeurope","properties":{"provisioningState":"Succeeded","fqdn":"%[2]s.example.com"}}`
+
+// fleetSender answers the subscription list and the cluster lists of the subscriptions, failing the "broken" one
+func fleetSender(calls *int32) autorest.Sender {
+	return autorest.SenderFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(calls, 1)
+		status, body := http.StatusOK, ""
+		switch path := req.URL.Path; {
+		case path == "/subscriptions":
+			body = `{"value":[{"subscriptionId":"sub1","state":"Enabled"},{"subscriptionId":"broken","state":"Enabled"},{"subscriptionId":"disabled","state":"Disabled"},{"subscriptionId":"sub2","state":"Warned"}]}`
+		case strings.HasPrefix(path, "/subscriptions/broken/"):
+			status, body = http.StatusForbidden, `{"error":{"code":"AuthorizationFailed","message":"no access"}}`
+		case strings.HasPrefix(path, "/subscriptions/"):
+			sub := strings.Split(path, "/")[2]
+			body = `{"value":[` + fmt.Sprintf(testCluster, sub, sub+"-a") + `,` + fmt.Sprintf(testCluster, sub, sub+"-b") + `]}`
+		default:
+			status = http.StatusNotFound
+		}
+		return &http.Response{
+			Status:     http.StatusText(status),
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       ioutil.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+}
+
+func withSender(sender autorest.Sender) Option {
+	return func(a *AKSClient) {
+		for _, c := range a.azureSdk.Clients() {
+			c.Authorizer = autorest.NullAuthorizer{}
+			c.Sender = sender
+		}
+	}
+}
+
+func TestFleetListClusters(t *testing.T) {
+	var calls int32
+	cl, err := GetAKSClient(&cluster.AKSCredential{
+		ClientId:       testClientId,
+		ClientSecret:   testClientSecret,
+		SubscriptionId: testSubscriptionId,
+		TenantId:       testTenantId,
+	}, withSender(fleetSender(&calls)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fleet := NewFleetClient(cl)
+	fleet.Parallelism = 2
+	response, err := fleet.ListClusters()
+	if err != nil {
+		t.Fatalf("Error during listing fleet: %s", err)
+	}
+
+	var names []string
+	for _, c := range response.Clusters {
+		if !strings.HasPrefix(c.Name, c.SubscriptionId) {
+			t.Errorf("Cluster %s tagged with subscription %s", c.Name, c.SubscriptionId)
+		}
+		names = append(names, c.Name)
+	}
+	if strings.Join(names, ",") != "sub1-a,sub1-b,sub2-a,sub2-b" {
+		t.Errorf("Unexpected clusters: %v", names)
+	}
+	if len(response.Errors) != 1 || response.Errors[0].SubscriptionId != "broken" {
+		t.Errorf("Expected error of the broken subscription, but got %v", response.Errors)
+	}
+	if calls != 4 {
+		t.Errorf("Expected 4 calls, but got %d", calls)
+	}
+
+	explicit, err := NewFleetClient(cl, "sub2").ListClusters()
+	if err != nil || len(explicit.Clusters) != 2 || explicit.Clusters[0].SubscriptionId != "sub2" {
+		t.Errorf("Unexpected result of configured subscriptions: %v, %v", explicit, err)
+	}
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/banzaicloud/azure-aks-client/audit"
+	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/azure-aks-client/hooks"
+	"github.com/banzaicloud/azure-aks-client/locking"
+	"github.com/banzaicloud/azure-aks-client/logging"
+	"github.com/banzaicloud/azure-aks-client/ratelimit"
+	"github.com/banzaicloud/azure-aks-client/recorder"
+	"github.com/banzaicloud/azure-aks-client/redact"
+	"github.com/banzaicloud/azure-aks-client/retry"
+)
+
+// Option customizes an *AKSClient created by GetAKSClient
+type Option func(*AKSClient)
+
+// WithSendDecorators wraps the Sender of every Azure SDK client with the passed decorators
+func WithSendDecorators(decorators ...autorest.SendDecorator) Option {
+	return func(a *AKSClient) {
+		a.azureSdk.DecorateSenders(decorators...)
+	}
+}
+
+// WithRecorder plugs the recorder into every Azure SDK client. In replay mode the clients don't request tokens
+// from Azure Active Directory either, so no real credentials are needed.
+func WithRecorder(r *recorder.Recorder) Option {
+	return func(a *AKSClient) {
+		a.azureSdk.DecorateSenders(r.SendDecorator())
+		r.ScrubValue(a.azureSdk.ServicePrincipal.ClientSecret)
+		if a.clusterIdentity != nil {
+			r.ScrubValue(a.clusterIdentity.ClientSecret)
+		}
+		if r.Mode() == recorder.ModeReplay {
+			for _, c := range a.azureSdk.Clients() {
+				c.Authorizer = autorest.NullAuthorizer{}
+			}
+		}
+	}
+}
+
+// WithRetryPolicy retries the calls of every Azure SDK client according to the policy. The retries of the calls of a
+// client returned by AKSClient.WithContext are counted in the retry.Counter of its context, see retry.WithCounter. Whatever the order of the options, the retries
+// wrap the rate limiter of WithRateLimiter, and both wrap the decorators of the other options.
+func WithRetryPolicy(p *retry.Policy) Option {
+	return func(a *AKSClient) {
+		a.retryPolicy = p
+	}
+}
+
+// WithRateLimiter charges every call of the Azure SDK clients to the read or write budget of its subscription in the
+// limiter, see ratelimit.Limiter.Subscription. Passing the same limiter to several clients makes the clients of a
+// subscription share its budget. Every attempt of a call retried by WithRetryPolicy is charged.
+func WithRateLimiter(l *ratelimit.Limiter) Option {
+	return func(a *AKSClient) {
+		a.rateLimiter = l
+	}
+}
+
+// WithTokenCache keeps the tokens of the client in cache instead of cluster.DefaultTokenCache, e.g. in a
+// cluster.FileTokenCache shared by short-lived processes
+func WithTokenCache(cache cluster.TokenCache) Option {
+	return func(a *AKSClient) {
+		a.azureSdk.Token.SetCache(cache)
+	}
+}
+
+// WithBackgroundTokenRefresh refreshes the client's token in the background before it expires, so calls don't wait
+// for Azure Active Directory. Refresh failures are logged by the client's logger. Close stops the refresh.
+func WithBackgroundTokenRefresh() Option {
+	return func(a *AKSClient) {
+		a.azureSdk.Token.OnRefreshError(func(err error) {
+			a.Logger().Error("Token refresh failed", logging.Fields{logging.FieldError: err.Error()})
+		})
+		a.azureSdk.Token.StartBackgroundRefresh()
+	}
+}
+
+// WithRedactor masks the log messages of the client with r instead of redact.Default, e.g. to add fields or patterns
+// only this client should mask
+func WithRedactor(r *redact.Redactor) Option {
+	return func(a *AKSClient) {
+		a.redactor = r
+	}
+}
+
+// WithLogger sets the logger of the client instead of the default logrus logger with JSON format, e.g.
+// logging.NewNopLogger() to discard every message
+func WithLogger(l logging.Logger) Option {
+	return func(a *AKSClient) {
+		a.logger = l
+	}
+}
+
+// WithAuditTrail records every create, update and delete of the client in t, see package audit
+func WithAuditTrail(t *audit.Trail) Option {
+	return func(a *AKSClient) {
+		a.auditTrail = t
+	}
+}
+
+// WithHooks runs the hooks of r around the create, update, scale, upgrade, delete and kubeconfig operations of the
+// client, see package hooks
+func WithHooks(r *hooks.Registry) Option {
+	return func(a *AKSClient) {
+		a.hooks = r
+	}
+}
+
+// WithLocking serializes the creates, updates and deletes of the same cluster through m, see package locking. Share
+// m between clients to serialize their operations too.
+func WithLocking(m *locking.Manager) Option {
+	return func(a *AKSClient) {
+		a.locks = m
+	}
+}
@@ -1,114 +1,450 @@
 package cluster
 
 import (
-	"os"
-
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2016-06-01/subscriptions"
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/adal"
-	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/banzaicloud/azure-aks-client/secrets"
 	"github.com/banzaicloud/azure-aks-client/utils"
+	"io"
+	"io/ioutil"
+	"net/url"
 )
 
 const AzureClientId = "AZURE_CLIENT_ID"
 const AzureClientSecret = "AZURE_CLIENT_SECRET"
 const AzureSubscriptionId = "AZURE_SUBSCRIPTION_ID"
 const AzureTenantId = "AZURE_TENANT_ID"
+const AzureCertificatePath = "AZURE_CERTIFICATE_PATH"
+const AzureCertificatePassword = "AZURE_CERTIFICATE_PASSWORD"
+const AzureClusterClientId = "AZURE_CLUSTER_CLIENT_ID"
+const AzureClusterClientSecret = "AZURE_CLUSTER_CLIENT_SECRET"
+const AzureAuthMethod = "AZURE_AUTH_METHOD"
+const AzureManagedIdentityEndpoint = "AZURE_MSI_ENDPOINT"
+const AzureTokenCachePath = "AZURE_TOKEN_CACHE_PATH"
+
+// AuthMethod is the way the client authenticates against Azure Active Directory
+type AuthMethod string
+
+const (
+	AuthMethodClientSecret      AuthMethod = "clientSecret"
+	AuthMethodClientCertificate AuthMethod = "clientCertificate"
+	// AuthMethodManagedIdentity gets tokens from the instance metadata endpoint of the Azure VM. The identity is
+	// user-assigned if ClientId is set, system-assigned otherwise.
+	AuthMethodManagedIdentity AuthMethod = "managedIdentity"
+	// AuthMethodDeviceCode signs in a human operator interactively. The verification URL and code are printed to
+	// DeviceCodeOutput, the token is cached in TokenCachePath.
+	AuthMethodDeviceCode AuthMethod = "deviceCode"
+)
 
 type AKSCredential struct {
 	ClientId       string
 	ClientSecret   string
 	SubscriptionId string
 	TenantId       string
+	// SecretSource provides the secrets when they're needed: secrets.ClientSecret on every token request and
+	// secrets.ClusterClientSecret on cluster creation. With a SecretSource, ClientSecret and the ClientSecret of
+	// ClusterIdentity are ignored, so no secret is kept by the client.
+	SecretSource secrets.SecretSource
+	// AuthMethod selects the authentication mode, if empty it's inferred from the other fields
+	AuthMethod AuthMethod
+	// CertificatePath is a PFX or PEM file holding the certificate and private key of the service principal
+	CertificatePath     string
+	CertificatePassword string
+	// ManagedIdentityEndpoint overrides the token endpoint of the instance metadata service
+	ManagedIdentityEndpoint string
+	// TokenCachePath is the base of the files the device code logins are cached in, one per tenant, client and
+	// resource, see DefaultTokenCachePath
+	TokenCachePath string
+	// DeviceCodeOutput receives the sign in instructions of the device code login, defaults to os.Stderr
+	DeviceCodeOutput io.Writer
+	// EnvironmentName selects the Azure cloud, see Environment
+	EnvironmentName string
+	// EnvironmentFile is a JSON file of custom cloud endpoints, it takes precedence over EnvironmentName
+	EnvironmentFile string
+	// ClusterIdentity is the service principal the created clusters run with. Without it, creating a cluster fails
+	// unless the request allows running with the management credential, reading clusters works either way.
+	ClusterIdentity *ClusterIdentity
+}
+
+// ClusterIdentity is the service principal set in the ServicePrincipalProfile of a managed cluster. Its secret is
+// either ClientSecret or a reference to a Key Vault secret.
+type ClusterIdentity struct {
+	ClientId       string
+	ClientSecret   string
+	KeyVaultSecret *KeyVaultSecretRef
+}
+
+// KeyVaultSecretRef references the Key Vault secret holding the secret of a service principal
+type KeyVaultSecretRef struct {
+	// VaultId is the resource ID of the key vault
+	VaultId    string
+	SecretName string
+	// Version of the secret, the latest if empty
+	Version string
+}
+
+// Validate checks that the identity has a client ID and exactly one kind of secret
+func (i *ClusterIdentity) Validate() error {
+	return i.validate(false)
+}
+
+// validate checks the identity, its ClientSecret may be missing if it's fetched from a SecretSource
+func (i *ClusterIdentity) validate(secretFromSource bool) error {
+	msg := "missing cluster identity: "
+	if i == nil {
+		return utils.NewErr(msg + "ClusterIdentity")
+	}
+	if len(i.ClientId) == 0 {
+		return utils.NewErr(msg + "ClientId")
+	}
+	if i.ClientSecret == SecretPlaceholder {
+		return utils.NewErr("cluster identity has the placeholder of an exported ClientSecret, set the secret")
+	}
+	if i.KeyVaultSecret == nil {
+		if len(i.ClientSecret) == 0 && !secretFromSource {
+			return utils.NewErr(msg + "ClientSecret or KeyVaultSecret")
+		}
+		return nil
+	}
+	if len(i.ClientSecret) != 0 {
+		return utils.NewErr("cluster identity has both ClientSecret and KeyVaultSecret")
+	}
+	if len(i.KeyVaultSecret.VaultId) == 0 || len(i.KeyVaultSecret.SecretName) == 0 {
+		return utils.NewErr(msg + "KeyVaultSecret VaultId or SecretName")
+	}
+	return nil
+}
+
+// Method returns the authentication mode of the credential
+func (a *AKSCredential) Method() AuthMethod {
+	if len(a.AuthMethod) != 0 {
+		return a.AuthMethod
+	}
+	if len(a.CertificatePath) != 0 {
+		return AuthMethodClientCertificate
+	}
+	return AuthMethodClientSecret
+}
+
+// complete tells whether the credential has the settings its auth method authenticates with
+func (a *AKSCredential) complete() bool {
+	if len(a.SubscriptionId) == 0 {
+		return false
+	}
+	switch a.Method() {
+	case AuthMethodClientSecret:
+		return len(a.ClientId) != 0 && len(a.TenantId) != 0 && (len(a.ClientSecret) != 0 || a.SecretSource != nil)
+	case AuthMethodClientCertificate:
+		return len(a.ClientId) != 0 && len(a.TenantId) != 0 && len(a.CertificatePath) != 0
+	case AuthMethodDeviceCode:
+		return len(a.TenantId) != 0
+	default:
+		// managed identity, an unknown method fails validation
+		return true
+	}
 }
 
 type Sdk struct {
-	ServicePrincipal        *ServicePrincipal
+	// CredentialSource is the name of the CredentialProvider the credentials came from
+	CredentialSource string
+	// Environment is the Azure cloud the clients talk to
+	Environment      azure.Environment
+	ServicePrincipal *ServicePrincipal
+	// Token provides the tokens of every SDK client through DefaultTokenCache, it can be moved to another cache by
+	// SetCache
+	Token                   *CachedToken
 	ManagedClusterClient    *containerservice.ManagedClustersClient
 	VMSizeClient            *compute.VirtualMachineSizesClient
 	SubscriptionsClient     *subscriptions.Client
+	TenantsClient           *subscriptions.TenantsClient
 	ContainerServicesClient *containerservice.ContainerServicesClient
 }
 
+// Clients returns the underlying autorest clients of every SDK client, so their Sender, Authorizer etc. can be
+// customized in one place
+func (s *Sdk) Clients() []*autorest.Client {
+	return []*autorest.Client{
+		&s.ManagedClusterClient.Client,
+		&s.VMSizeClient.Client,
+		&s.SubscriptionsClient.Client,
+		&s.TenantsClient.Client,
+		&s.ContainerServicesClient.Client,
+	}
+}
+
+// ForSubscription returns a copy of the Sdk calling the subscription with the same token, Sender decorators etc.
+// The subscription must belong to the tenant the credentials authenticate against.
+func (s *Sdk) ForSubscription(subscriptionId string) *Sdk {
+	servicePrincipal := *s.ServicePrincipal
+	servicePrincipal.SubscriptionID = subscriptionId
+	servicePrincipal.HashMap = make(map[string]string, len(s.ServicePrincipal.HashMap))
+	for k, v := range s.ServicePrincipal.HashMap {
+		servicePrincipal.HashMap[k] = v
+	}
+	servicePrincipal.HashMap[AzureSubscriptionId] = subscriptionId
+
+	managedClusterClient := *s.ManagedClusterClient
+	managedClusterClient.SubscriptionID = subscriptionId
+	vmSizesClient := *s.VMSizeClient
+	vmSizesClient.SubscriptionID = subscriptionId
+	containerServicesClient := *s.ContainerServicesClient
+	containerServicesClient.SubscriptionID = subscriptionId
+
+	sdk := *s
+	sdk.ServicePrincipal = &servicePrincipal
+	sdk.ManagedClusterClient = &managedClusterClient
+	sdk.VMSizeClient = &vmSizesClient
+	sdk.ContainerServicesClient = &containerServicesClient
+	return &sdk
+}
+
+// DecorateSenders wraps the Sender of every SDK client with the passed decorators
+func (s *Sdk) DecorateSenders(decorators ...autorest.SendDecorator) {
+	for _, c := range s.Clients() {
+		c.Sender = autorest.DecorateSender(c.Sender, decorators...)
+	}
+}
+
 type ServicePrincipal struct {
 	ClientID           string
 	ClientSecret       string
 	SubscriptionID     string
 	TenantId           string
+	AuthMethod         AuthMethod
+	ClusterIdentity    *ClusterIdentity
+	SecretSource       secrets.SecretSource
 	HashMap            map[string]string
 	AuthenticatedToken *adal.ServicePrincipalToken
 }
 
 func (a *AKSCredential) Validate() error {
 	msg := "missing credential: "
-	if len(a.ClientId) == 0 {
+	method := a.Method()
+	if _, err := a.Environment(); err != nil {
+		return utils.NewErr(fmt.Sprintf("invalid environment: %s", err))
+	}
+	if len(a.ClientId) == 0 && method != AuthMethodManagedIdentity && method != AuthMethodDeviceCode {
 		return utils.NewErr(msg + "clientId")
 	}
-	if len(a.ClientSecret) == 0 {
-		return utils.NewErr(msg + "ClientSecret")
+	switch method {
+	case AuthMethodClientSecret:
+		if len(a.ClientSecret) == 0 && a.SecretSource == nil {
+			return utils.NewErr(msg + "ClientSecret")
+		}
+	case AuthMethodClientCertificate:
+		if len(a.CertificatePath) == 0 {
+			return utils.NewErr(msg + "CertificatePath")
+		}
+	case AuthMethodManagedIdentity, AuthMethodDeviceCode:
+	default:
+		return utils.NewErr(fmt.Sprintf("unknown auth method: %s", a.AuthMethod))
+	}
+	if a.ClusterIdentity != nil {
+		if err := a.ClusterIdentity.validate(a.SecretSource != nil); err != nil {
+			return err
+		}
 	}
 	if len(a.SubscriptionId) == 0 {
 		return utils.NewErr(msg + "SubscriptionId")
 	}
-	if len(a.TenantId) == 0 {
+	if len(a.TenantId) == 0 && method != AuthMethodManagedIdentity {
 		return utils.NewErr(msg + "TenantId")
 	}
 	return nil
 }
 
+// Authenticate creates the SDK clients with the passed credentials. If credentials is nil, they are resolved by
+// the rest of the DefaultCredentialChain.
 func Authenticate(credentials *AKSCredential) (*Sdk, error) {
-	var AKSCred *AKSCredential
-	if credentials != nil {
-		AKSCred = credentials
-	} else {
-		AKSCred = &AKSCredential{}
-		AKSCred.ClientId = os.Getenv(AzureClientId)
-		AKSCred.ClientSecret = os.Getenv(AzureClientSecret)
-		AKSCred.SubscriptionId = os.Getenv(AzureSubscriptionId)
-		AKSCred.TenantId = os.Getenv(AzureTenantId)
-	}
-
-	err := AKSCred.Validate()
+	return AuthenticateWithProvider(DefaultCredentialChain(credentials))
+}
+
+// AuthenticateWithProvider creates the SDK clients with the credentials resolved by the provider
+func AuthenticateWithProvider(provider CredentialProvider) (*Sdk, error) {
+	AKSCred, err := provider.Retrieve()
+	if err != nil {
+		return nil, err
+	}
+
+	err = AKSCred.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if AKSCred.SecretSource != nil {
+		AKSCred = withoutSecrets(AKSCred)
+	}
+
+	env, err := AKSCred.Environment()
 	if err != nil {
 		return nil, err
 	}
 
 	sdk := Sdk{
+		CredentialSource: provider.Name(),
+		Environment:      env,
 		ServicePrincipal: &ServicePrincipal{
-			ClientID:       AKSCred.ClientId,
-			ClientSecret:   AKSCred.ClientSecret,
-			SubscriptionID: AKSCred.SubscriptionId,
-			TenantId:       AKSCred.TenantId,
+			ClientID:        AKSCred.ClientId,
+			ClientSecret:    AKSCred.ClientSecret,
+			SubscriptionID:  AKSCred.SubscriptionId,
+			TenantId:        AKSCred.TenantId,
+			AuthMethod:      AKSCred.Method(),
+			ClusterIdentity: AKSCred.ClusterIdentity,
+			SecretSource:    AKSCred.SecretSource,
 			HashMap: map[string]string{
 				AzureClientId:       AKSCred.ClientId,
 				AzureClientSecret:   AKSCred.ClientSecret,
 				AzureSubscriptionId: AKSCred.SubscriptionId,
 				AzureTenantId:       AKSCred.TenantId,
+				AzureEnvironment:    env.Name,
 			},
 		},
 	}
-	authorizer, err := auth.NewClientCredentialsConfig(AKSCred.ClientId, AKSCred.ClientSecret, AKSCred.TenantId).Authorizer()
+	if len(AKSCred.EnvironmentFile) != 0 {
+		sdk.ServicePrincipal.HashMap[AzureEnvironmentFile] = AKSCred.EnvironmentFile
+	}
+	token, err := newServicePrincipalToken(AKSCred, env)
 	if err != nil {
 		return nil, utils.NewErr(fmt.Sprintf("authentication error: %s", err))
 	}
+	sdk.ServicePrincipal.AuthenticatedToken = token
+	sdk.Token = NewCachedToken(tokenCacheKey(AKSCred, env), token, DefaultTokenCache)
+	authorizer := autorest.NewBearerAuthorizer(sdk.Token)
 
 	subscriptionId := sdk.ServicePrincipal.SubscriptionID
-	managedClusterClient := containerservice.NewManagedClustersClient(subscriptionId)
-	vmSizesClient := compute.NewVirtualMachineSizesClient(subscriptionId)
-	subscriptionsClient := subscriptions.NewClient()
-	containerServicesClient := containerservice.NewContainerServicesClient(subscriptionId)
+	baseURI := env.ResourceManagerEndpoint
+	managedClusterClient := containerservice.NewManagedClustersClientWithBaseURI(baseURI, subscriptionId)
+	vmSizesClient := compute.NewVirtualMachineSizesClientWithBaseURI(baseURI, subscriptionId)
+	subscriptionsClient := subscriptions.NewClientWithBaseURI(baseURI)
+	tenantsClient := subscriptions.NewTenantsClientWithBaseURI(baseURI)
+	containerServicesClient := containerservice.NewContainerServicesClientWithBaseURI(baseURI, subscriptionId)
 
 	managedClusterClient.Authorizer = authorizer
 	vmSizesClient.Authorizer = authorizer
 	subscriptionsClient.Authorizer = authorizer
+	tenantsClient.Authorizer = authorizer
 	containerServicesClient.Authorizer = authorizer
 
 	sdk.ManagedClusterClient = &managedClusterClient
 	sdk.VMSizeClient = &vmSizesClient
 	sdk.SubscriptionsClient = &subscriptionsClient
+	sdk.TenantsClient = &tenantsClient
 	sdk.ContainerServicesClient = &containerServicesClient
 
 	return &sdk, nil
 }
+
+// tokenCacheKey returns the key the tokens of the credential are cached with
+func tokenCacheKey(credential *AKSCredential, env azure.Environment) TokenCacheKey {
+	clientId := credential.ClientId
+	if credential.Method() == AuthMethodDeviceCode {
+		clientId = deviceCodeClientId(credential)
+	}
+	return TokenCacheKey{
+		TenantId:   credential.TenantId,
+		ClientId:   clientId,
+		Resource:   tokenAudience(env),
+		Credential: credentialHash(credential),
+	}
+}
+
+// withoutSecrets returns a copy of the credential without the secrets its SecretSource provides
+func withoutSecrets(credential *AKSCredential) *AKSCredential {
+	c := *credential
+	c.ClientSecret = ""
+	if identity := c.ClusterIdentity; identity != nil && identity.KeyVaultSecret == nil {
+		c.ClusterIdentity = &ClusterIdentity{ClientId: identity.ClientId}
+	}
+	return &c
+}
+
+// credentialHash returns the hash of the auth method and the credential material. A secret fetched from a
+// SecretSource is unknown until the token is requested, so such a credential is hashed with the identity of its
+// source, see secrets.Identity.
+func credentialHash(credential *AKSCredential) string {
+	hash := sha256.New()
+	write := func(values ...string) {
+		for _, v := range values {
+			fmt.Fprintf(hash, "%d:%s;", len(v), v)
+		}
+	}
+	method := credential.Method()
+	write(string(method))
+	switch method {
+	case AuthMethodManagedIdentity:
+		write(credential.ManagedIdentityEndpoint)
+	case AuthMethodDeviceCode:
+		write(credential.TokenCachePath)
+	case AuthMethodClientCertificate:
+		certificate, err := ioutil.ReadFile(credential.CertificatePath)
+		if err != nil {
+			certificate = []byte(credential.CertificatePath)
+		}
+		write(string(certificate), credential.CertificatePassword)
+	default:
+		if len(credential.ClientSecret) == 0 && credential.SecretSource != nil {
+			write("source", secrets.Identity(credential.SecretSource))
+		} else {
+			write(credential.ClientSecret)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// newServicePrincipalToken creates the token of the ARM calls in env according to the auth method of the credential
+func newServicePrincipalToken(credential *AKSCredential, env azure.Environment) (*adal.ServicePrincipalToken, error) {
+	resource := tokenAudience(env)
+
+	if credential.Method() == AuthMethodManagedIdentity {
+		endpoint := credential.ManagedIdentityEndpoint
+		if len(endpoint) == 0 {
+			endpoint, _ = adal.GetMSIVMEndpoint()
+		}
+		if len(credential.ClientId) != 0 {
+			return adal.NewServicePrincipalTokenFromMSIWithUserAssignedID(endpoint, resource, credential.ClientId)
+		}
+		return adal.NewServicePrincipalTokenFromMSI(endpoint, resource)
+	}
+
+	oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, credential.TenantId)
+	if err != nil {
+		return nil, err
+	}
+
+	switch credential.Method() {
+	case AuthMethodDeviceCode:
+		return newDeviceCodeToken(*oauthConfig, credential, resource)
+	case AuthMethodClientCertificate:
+		certificate, privateKey, err := LoadCertificate(credential.CertificatePath, credential.CertificatePassword)
+		if err != nil {
+			return nil, err
+		}
+		return adal.NewServicePrincipalTokenFromCertificate(*oauthConfig, credential.ClientId, certificate, privateKey, resource)
+	default:
+		if len(credential.ClientSecret) == 0 && credential.SecretSource != nil {
+			secret := &sourcedSecret{source: credential.SecretSource}
+			return adal.NewServicePrincipalTokenWithSecret(*oauthConfig, credential.ClientId, resource, secret)
+		}
+		return adal.NewServicePrincipalToken(*oauthConfig, credential.ClientId, credential.ClientSecret, resource)
+	}
+}
+
+// sourcedSecret fetches the client secret from the SecretSource on every token request instead of keeping it
+type sourcedSecret struct {
+	source secrets.SecretSource
+}
+
+func (s *sourcedSecret) SetAuthenticationValues(spt *adal.ServicePrincipalToken, v *url.Values) error {
+	secret, err := s.source.Get(secrets.ClientSecret)
+	if err != nil {
+		return fmt.Errorf("failed to get client secret: %s", err)
+	}
+	v.Set("client_secret", secret)
+	return nil
+}
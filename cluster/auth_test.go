@@ -0,0 +1,204 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/banzaicloud/azure-aks-client/secrets"
+)
+
+func TestAuthenticateWithManagedIdentity(t *testing.T) {
+	var query map[string][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			t.Error("Expected Metadata header")
+		}
+		query = r.URL.Query()
+		w.Write([]byte(`{"access_token":"token","expires_in":"3600","expires_on":"4102444800","resource":"https://management.azure.com/","token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	credential := &AKSCredential{
+		AuthMethod:              AuthMethodManagedIdentity,
+		ClientId:                "userAssignedId",
+		SubscriptionId:          "subscriptionId",
+		ManagedIdentityEndpoint: server.URL,
+		ClusterIdentity:         &ClusterIdentity{ClientId: "clusterClientId", ClientSecret: "clusterSecret"},
+	}
+
+	sdk, err := Authenticate(credential)
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+	if sdk.ServicePrincipal.AuthMethod != AuthMethodManagedIdentity {
+		t.Errorf("Expected managed identity authentication, but got %s", sdk.ServicePrincipal.AuthMethod)
+	}
+
+	token := sdk.ServicePrincipal.AuthenticatedToken
+	if err := token.EnsureFresh(); err != nil {
+		t.Fatalf("Error during getting token: %s", err)
+	}
+	if token.OAuthToken() != "token" {
+		t.Errorf("Expected token from the metadata endpoint, but got %s", token.OAuthToken())
+	}
+	if query["client_id"][0] != "userAssignedId" || query["resource"][0] != "https://management.azure.com/" {
+		t.Errorf("Unexpected token request: %v", query)
+	}
+}
+
+func TestAuthenticateWithCachedDeviceCode(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aks-device-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cachePath := deviceCodeCachePath(filepath.Join(dir, "token.json"), "tenantId", DefaultDeviceCodeClientId, "https://management.azure.com/")
+	cached := adal.Token{
+		AccessToken:  "cachedToken",
+		RefreshToken: "refreshToken",
+		ExpiresIn:    "3600",
+		ExpiresOn:    "4102444800",
+		Resource:     "https://management.azure.com/",
+		Type:         "Bearer",
+	}
+	if err := adal.SaveToken(cachePath, 0600, cached); err != nil {
+		t.Fatal(err)
+	}
+
+	credential := &AKSCredential{
+		AuthMethod:      AuthMethodDeviceCode,
+		SubscriptionId:  "subscriptionId",
+		TenantId:        "tenantId",
+		TokenCachePath:  filepath.Join(dir, "token.json"),
+		ClusterIdentity: &ClusterIdentity{ClientId: "clusterClientId", ClientSecret: "clusterSecret"},
+	}
+
+	sdk, err := Authenticate(credential)
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+	token := sdk.ServicePrincipal.AuthenticatedToken
+	if token.OAuthToken() != "cachedToken" {
+		t.Errorf("Expected cached token, but got %s", token.OAuthToken())
+	}
+	info, err := os.Stat(cachePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected token cache with 0600 permissions, but got %v", info.Mode().Perm())
+	}
+}
+
+func TestDeviceCodeLogin(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aks-device-code")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the server stands in for Azure Active Directory, the user signs in after two polls
+	var codes, polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/oauth2/devicecode"):
+			n := atomic.AddInt32(&codes, 1)
+			fmt.Fprintf(w, `{"device_code":"device%d","user_code":"CODE%d","verification_url":"https://microsoft.com/devicelogin","expires_in":"900","interval":"0","message":"sign in with CODE%d"}`, n, n, n)
+		case strings.HasSuffix(r.URL.Path, "/oauth2/token"):
+			if atomic.AddInt32(&polls, 1)%3 != 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"authorization_pending"}`))
+				return
+			}
+			fmt.Fprintf(w, `{"access_token":"token-%s","refresh_token":"refresh","expires_in":"3600","expires_on":"4102444800","resource":"%s","token_type":"Bearer"}`,
+				r.Form.Get("code"), r.Form.Get("resource"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	login := func(tenantId string) (*adal.ServicePrincipalToken, string) {
+		var output bytes.Buffer
+		credential := &AKSCredential{TenantId: tenantId, TokenCachePath: filepath.Join(dir, "token.json"), DeviceCodeOutput: &output}
+		oauthConfig, err := adal.NewOAuthConfig(server.URL, tenantId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := newDeviceCodeToken(*oauthConfig, credential, "https://management.azure.com/")
+		if err != nil {
+			t.Fatalf("Error during device code login: %s", err)
+		}
+		return token, output.String()
+	}
+
+	token, output := login("tenantId")
+	if token.OAuthToken() != "token-device1" || output != "sign in with CODE1\n" || atomic.LoadInt32(&polls) != 3 {
+		t.Errorf("Expected the token of the first login, but got %s after %d polls, output %q", token.OAuthToken(), atomic.LoadInt32(&polls), output)
+	}
+	cachePath := deviceCodeCachePath(filepath.Join(dir, "token.json"), "tenantId", DefaultDeviceCodeClientId, "https://management.azure.com/")
+	if info, err := os.Stat(cachePath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the token cached with 0600 permissions, but got %v", err)
+	}
+
+	if token, output := login("tenantId"); token.OAuthToken() != "token-device1" || len(output) != 0 || atomic.LoadInt32(&codes) != 1 {
+		t.Errorf("Expected the cached token, but got %s, output %q", token.OAuthToken(), output)
+	}
+	if token, _ := login("otherTenantId"); token.OAuthToken() != "token-device2" || atomic.LoadInt32(&codes) != 2 {
+		t.Errorf("Expected a new login for another tenant, but got %s", token.OAuthToken())
+	}
+}
+
+func TestAuthenticateWithSecretSource(t *testing.T) {
+	var form url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+		w.Write([]byte(`{"access_token":"token","expires_in":"3600","expires_on":"4102444800","resource":"https://management.azure.com/","token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "aks-environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprintf(file, `{"name":"Test","resourceManagerEndpoint":"https://management.azure.com/","activeDirectoryEndpoint":"%s/"}`, server.URL)
+	file.Close()
+
+	os.Setenv("TEST_SOURCED_SECRET", "sourcedSecret")
+	defer os.Unsetenv("TEST_SOURCED_SECRET")
+	credential := &AKSCredential{
+		ClientId:        "clientId",
+		SubscriptionId:  "subscriptionId",
+		TenantId:        "tenantId",
+		EnvironmentFile: file.Name(),
+		SecretSource:    &secrets.EnvSource{Vars: map[string]string{secrets.ClientSecret: "TEST_SOURCED_SECRET"}},
+	}
+
+	sdk, err := Authenticate(credential)
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+	if sdk.ServicePrincipal.ClientSecret != "" || sdk.ServicePrincipal.HashMap[AzureClientSecret] != "" {
+		t.Error("Expected no stored secret")
+	}
+	if err := sdk.Token.EnsureFresh(); err != nil {
+		t.Fatalf("Error during getting token: %s", err)
+	}
+	if form.Get("client_secret") != "sourcedSecret" || form.Get("client_id") != "clientId" {
+		t.Errorf("Unexpected token request: %v", form)
+	}
+}
@@ -0,0 +1,252 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"hash"
+	"io/ioutil"
+
+	"github.com/banzaicloud/azure-aks-client/utils"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/pkcs12"
+)
+
+// LoadCertificate loads the certificate and RSA private key of a service principal from a PFX (PKCS#12) or PEM file.
+// The password decrypts the PFX file or an encrypted PEM private key, and may be empty. When the file holds a chain,
+// the certificate of the private key is returned.
+func LoadCertificate(path, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, utils.NewErr(fmt.Sprintf("failed to read certificate %s: %s", path, err))
+	}
+
+	if bytes.Contains(data, []byte("-----BEGIN")) {
+		return decodePEM(data, password)
+	}
+	return decodePFX(data, password)
+}
+
+// decodePFX decodes a PKCS#12 file, which may hold the chain of the certificate as well
+func decodePFX(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	blocks, err := pkcs12.ToPEM(data, password)
+	if err != nil {
+		return nil, nil, utils.NewErr(fmt.Sprintf("failed to decode PFX certificate: %s", err))
+	}
+
+	var certificates []*x509.Certificate
+	var privateKey *rsa.PrivateKey
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, utils.NewErr(fmt.Sprintf("failed to parse PFX certificate: %s", err))
+			}
+			certificates = append(certificates, certificate)
+		case "PRIVATE KEY":
+			if privateKey != nil {
+				return nil, nil, utils.NewErr("PFX certificate must contain a single private key")
+			}
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, utils.NewErr("PFX certificate must contain an RSA private key")
+			}
+			privateKey = key
+		}
+	}
+
+	if privateKey == nil {
+		return nil, nil, utils.NewErr("PFX certificate doesn't contain a private key")
+	}
+	certificate, err := leafCertificate(certificates, privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, privateKey, nil
+}
+
+// decodePEM decodes a PEM file holding a certificate, optionally with its chain, and a PKCS#1 or PKCS#8 private key
+func decodePEM(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	var certificates []*x509.Certificate
+	var privateKey *rsa.PrivateKey
+
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, nil, utils.NewErr(fmt.Sprintf("failed to parse certificate: %s", err))
+			}
+			certificates = append(certificates, certificate)
+		case "RSA PRIVATE KEY", "PRIVATE KEY", "ENCRYPTED PRIVATE KEY":
+			key, err := parsePrivateKey(block, password)
+			if err != nil {
+				return nil, nil, err
+			}
+			privateKey = key
+		}
+	}
+
+	if len(certificates) == 0 {
+		return nil, nil, utils.NewErr("PEM file doesn't contain a certificate")
+	}
+	if privateKey == nil {
+		return nil, nil, utils.NewErr("PEM file doesn't contain an RSA private key")
+	}
+	certificate, err := leafCertificate(certificates, privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, privateKey, nil
+}
+
+// leafCertificate returns the certificate of the private key from a chain
+func leafCertificate(certificates []*x509.Certificate, privateKey *rsa.PrivateKey) (*x509.Certificate, error) {
+	for _, certificate := range certificates {
+		if publicKey, ok := certificate.PublicKey.(*rsa.PublicKey); ok &&
+			publicKey.N.Cmp(privateKey.N) == 0 && publicKey.E == privateKey.E {
+			return certificate, nil
+		}
+	}
+	return nil, utils.NewErr("no certificate matches the private key")
+}
+
+// parsePrivateKey parses a PEM private key block, decrypting it with the password if it's encrypted
+func parsePrivateKey(block *pem.Block, password string) (*rsa.PrivateKey, error) {
+	der := block.Bytes
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		decrypted, err := decryptPKCS8(der, []byte(password))
+		if err != nil {
+			return nil, utils.NewErr(fmt.Sprintf("failed to decrypt private key: %s", err))
+		}
+		der = decrypted
+	case x509.IsEncryptedPEMBlock(block):
+		decrypted, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return nil, utils.NewErr(fmt.Sprintf("failed to decrypt private key: %s", err))
+		}
+		der = decrypted
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, utils.NewErr(fmt.Sprintf("failed to parse private key: %s", err))
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, utils.NewErr("private key must be an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// PKCS#5 v2 (PBES2) identifiers of the encrypted PKCS#8 keys openssl writes
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+	oidDESEDE3CBC     = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+)
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// decryptPKCS8 decrypts an ENCRYPTED PRIVATE KEY block, only the PBES2 scheme with PBKDF2 and AES or 3DES is supported
+func decryptPKCS8(der, password []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported encryption %s, only PBES2 is supported", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	if !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s, only PBKDF2 is supported", params.KeyDerivationFunc.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf); err != nil {
+		return nil, err
+	}
+
+	var prf func() hash.Hash
+	switch {
+	case len(kdf.PRF.Algorithm) == 0 || kdf.PRF.Algorithm.Equal(oidHMACWithSHA1):
+		prf = sha1.New
+	case kdf.PRF.Algorithm.Equal(oidHMACWithSHA256):
+		prf = sha256.New
+	default:
+		return nil, fmt.Errorf("unsupported PBKDF2 function %s", kdf.PRF.Algorithm)
+	}
+
+	var keySize int
+	var newCipher func([]byte) (cipher.Block, error)
+	switch scheme := params.EncryptionScheme.Algorithm; {
+	case scheme.Equal(oidAES128CBC):
+		keySize, newCipher = 16, aes.NewCipher
+	case scheme.Equal(oidAES192CBC):
+		keySize, newCipher = 24, aes.NewCipher
+	case scheme.Equal(oidAES256CBC):
+		keySize, newCipher = 32, aes.NewCipher
+	case scheme.Equal(oidDESEDE3CBC):
+		keySize, newCipher = 24, des.NewTripleDESCipher
+	default:
+		return nil, fmt.Errorf("unsupported cipher %s", scheme)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+
+	block, err := newCipher(pbkdf2.Key(password, kdf.Salt, kdf.IterationCount, keySize, prf))
+	if err != nil {
+		return nil, err
+	}
+	data := info.EncryptedData
+	if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("invalid encrypted data")
+	}
+	plain := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, data)
+
+	// a wrong password shows up as invalid padding
+	padding := int(plain[len(plain)-1])
+	if padding == 0 || padding > block.BlockSize() || !bytes.Equal(plain[len(plain)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, fmt.Errorf("incorrect password")
+	}
+	return plain[:len(plain)-padding], nil
+}
@@ -0,0 +1,107 @@
+package cluster
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testCertificatePassword = "password"
+
+// writeTestCertificate writes a self-signed certificate with an encrypted private key into a PEM file
+func writeTestCertificate(t *testing.T, dir string) string {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "aks-client-test"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBlock, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(key), []byte(testCertificatePassword), x509.PEMCipherAES256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "cert.pem")
+	content := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(keyBlock)...)
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := writeTestCertificate(t, dir)
+
+	certificate, key, err := LoadCertificate(path, testCertificatePassword)
+	if err != nil {
+		t.Fatalf("Error during loading certificate: %s", err)
+	}
+	if certificate.Subject.CommonName != "aks-client-test" || key == nil {
+		t.Errorf("Unexpected certificate %v", certificate.Subject)
+	}
+
+	if _, _, err := LoadCertificate(path, "wrong"); err == nil {
+		t.Error("Expected error for wrong password")
+	}
+}
+
+func TestLoadCertificateFixtures(t *testing.T) {
+	for _, path := range []string{"testdata/chain.pfx", "testdata/pkcs8.pem"} {
+		certificate, key, err := LoadCertificate(path, testCertificatePassword)
+		if err != nil {
+			t.Errorf("Error during loading %s: %s", path, err)
+			continue
+		}
+		if certificate.Subject.CommonName != "aks-client-test" || key == nil {
+			t.Errorf("Expected the leaf certificate of %s, but got %v", path, certificate.Subject)
+		}
+		if _, _, err := LoadCertificate(path, "wrong"); err == nil {
+			t.Errorf("Expected error for wrong password of %s", path)
+		}
+	}
+}
+
+func TestAuthenticateWithCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	credential := &AKSCredential{
+		ClientId:            "clientId",
+		SubscriptionId:      "subscriptionId",
+		TenantId:            "tenantId",
+		CertificatePath:     writeTestCertificate(t, dir),
+		CertificatePassword: testCertificatePassword,
+	}
+	// without a cluster identity the client can still read clusters
+	sdk, err := Authenticate(credential)
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+	if sdk.ServicePrincipal.AuthMethod != AuthMethodClientCertificate || sdk.ServicePrincipal.AuthenticatedToken == nil {
+		t.Errorf("Expected certificate authentication, but got %s", sdk.ServicePrincipal.AuthMethod)
+	}
+}
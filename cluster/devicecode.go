@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// DefaultDeviceCodeClientId is the public client ID of the Azure CLI, used by the device code flow when no
+// ClientId is set
+const DefaultDeviceCodeClientId = "04b07795-8ddb-461a-bbee-02f9e1bf7b46"
+
+// DefaultTokenCachePath returns the base of the files the device code flow caches its tokens in if no
+// TokenCachePath is set, see deviceCodeCachePath
+func DefaultTokenCachePath() string {
+	return filepath.Join(os.Getenv("HOME"), ".azure", "aks-client-token.json")
+}
+
+// deviceCodeCachePath returns the file caching the device code login of the tenant, client and resource, named
+// after base with a hash of them. A login to another tenant or cloud doesn't reuse the token of another audience.
+func deviceCodeCachePath(base, tenantId, clientId, resource string) string {
+	sum := sha256.Sum256([]byte(tenantId + "\n" + clientId + "\n" + resource))
+	ext := filepath.Ext(base)
+	return strings.TrimSuffix(base, ext) + "-" + hex.EncodeToString(sum[:8]) + ext
+}
+
+// deviceCodeClientId returns the client the device code login signs in to
+func deviceCodeClientId(credential *AKSCredential) string {
+	if len(credential.ClientId) == 0 {
+		return DefaultDeviceCodeClientId
+	}
+	return credential.ClientId
+}
+
+// newDeviceCodeToken returns a token of the cached device code login, or starts a new one and waits until the user
+// signs in. The refreshed tokens are written back to the cache.
+func newDeviceCodeToken(oauthConfig adal.OAuthConfig, credential *AKSCredential, resource string) (*adal.ServicePrincipalToken, error) {
+	clientId := deviceCodeClientId(credential)
+	cachePath := credential.TokenCachePath
+	if len(cachePath) == 0 {
+		cachePath = DefaultTokenCachePath()
+	}
+	cachePath = deviceCodeCachePath(cachePath, credential.TenantId, clientId, resource)
+	saveToken := func(token adal.Token) error {
+		return adal.SaveToken(cachePath, 0600, token)
+	}
+
+	if cached, err := adal.LoadToken(cachePath); err == nil {
+		spt, err := adal.NewServicePrincipalTokenFromManualToken(oauthConfig, clientId, resource, *cached, saveToken)
+		if err == nil && (!cached.IsExpired() || spt.Refresh() == nil) {
+			return spt, nil
+		}
+	}
+
+	sender := &http.Client{}
+	code, err := adal.InitiateDeviceAuth(sender, oauthConfig, clientId, resource)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device code login: %s", err)
+	}
+
+	output := credential.DeviceCodeOutput
+	if output == nil {
+		output = os.Stderr
+	}
+	if code.Message != nil {
+		fmt.Fprintln(output, *code.Message)
+	} else if code.VerificationURL != nil && code.UserCode != nil {
+		fmt.Fprintf(output, "To sign in, open %s and enter the code %s\n", *code.VerificationURL, *code.UserCode)
+	}
+
+	token, err := adal.WaitForUserCompletion(sender, code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to finish device code login: %s", err)
+	}
+	if err := saveToken(*token); err != nil {
+		return nil, err
+	}
+
+	return adal.NewServicePrincipalTokenFromManualToken(oauthConfig, clientId, resource, *token, saveToken)
+}
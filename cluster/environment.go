@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+const AzureEnvironment = "AZURE_ENVIRONMENT"
+
+// AzureEnvironmentFile is the environment variable of a custom endpoints file, the same one autorest uses for
+// Azure Stack
+const AzureEnvironmentFile = azure.EnvironmentFilepathName
+
+// environmentAliases maps the short cloud names to the names autorest knows
+var environmentAliases = map[string]string{
+	"public":       azure.PublicCloud.Name,
+	"china":        azure.ChinaCloud.Name,
+	"usgovernment": azure.USGovernmentCloud.Name,
+	"german":       azure.GermanCloud.Name,
+}
+
+// Environment returns the Azure cloud of the credential. EnvironmentFile takes precedence over Environment, which
+// is either a short name (public, china, usgovernment, german) or an autorest one like AzureChinaCloud. It defaults
+// to the public cloud.
+func (a *AKSCredential) Environment() (azure.Environment, error) {
+	if len(a.EnvironmentFile) != 0 {
+		env, err := azure.EnvironmentFromFile(a.EnvironmentFile)
+		if err != nil {
+			return env, fmt.Errorf("failed to read environment file %s: %s", a.EnvironmentFile, err)
+		}
+		if len(env.ResourceManagerEndpoint) == 0 || len(env.ActiveDirectoryEndpoint) == 0 {
+			return env, fmt.Errorf("environment file %s misses resourceManagerEndpoint or activeDirectoryEndpoint", a.EnvironmentFile)
+		}
+		return env, nil
+	}
+	if len(a.EnvironmentName) == 0 {
+		return azure.PublicCloud, nil
+	}
+	name := a.EnvironmentName
+	if alias, ok := environmentAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+	return azure.EnvironmentFromName(name)
+}
+
+// environmentOfEndpoints returns the name of the Azure cloud with the Resource Manager and Active Directory endpoints
+// of an SDK auth file, or an empty name if neither is set. Endpoints of other clouds, like Azure Stack, need an
+// environment file.
+func environmentOfEndpoints(resourceManager, activeDirectory string) (string, error) {
+	if len(resourceManager) == 0 && len(activeDirectory) == 0 {
+		return "", nil
+	}
+	same := func(endpoint, configured string) bool {
+		return len(configured) == 0 || strings.EqualFold(strings.TrimSuffix(endpoint, "/"), strings.TrimSuffix(configured, "/"))
+	}
+	for _, env := range []azure.Environment{azure.PublicCloud, azure.ChinaCloud, azure.USGovernmentCloud, azure.GermanCloud} {
+		if same(env.ResourceManagerEndpoint, resourceManager) && same(env.ActiveDirectoryEndpoint, activeDirectory) {
+			return env.Name, nil
+		}
+	}
+	return "", fmt.Errorf("no Azure cloud has the endpoints %s and %s, set environmentFile", resourceManager, activeDirectory)
+}
+
+// tokenAudience returns the resource the ARM tokens are requested for in the environment
+func tokenAudience(env azure.Environment) string {
+	if len(env.TokenAudience) != 0 {
+		return env.TokenAudience
+	}
+	return env.ResourceManagerEndpoint
+}
@@ -0,0 +1,69 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+func TestEnvironment(t *testing.T) {
+	file, err := ioutil.TempFile("", "aks-environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"name":"AzureStackCloud","resourceManagerEndpoint":"https://management.local.azurestack.external/","activeDirectoryEndpoint":"https://login.local.azurestack.external/"}`)
+	file.Close()
+
+	cases := []struct {
+		name       string
+		credential AKSCredential
+		expected   string
+		fails      bool
+	}{
+		{name: "default", expected: azure.PublicCloud.ResourceManagerEndpoint},
+		{name: "short name", credential: AKSCredential{EnvironmentName: "china"}, expected: azure.ChinaCloud.ResourceManagerEndpoint},
+		{name: "autorest name", credential: AKSCredential{EnvironmentName: "AzureUSGovernmentCloud"}, expected: azure.USGovernmentCloud.ResourceManagerEndpoint},
+		{name: "file", credential: AKSCredential{EnvironmentName: "china", EnvironmentFile: file.Name()}, expected: "https://management.local.azurestack.external/"},
+		{name: "unknown name", credential: AKSCredential{EnvironmentName: "moon"}, fails: true},
+		{name: "missing file", credential: AKSCredential{EnvironmentFile: file.Name() + ".missing"}, fails: true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := tc.credential.Environment()
+			if tc.fails {
+				if err == nil {
+					t.Error("Expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error during resolving environment: %s", err)
+			}
+			if env.ResourceManagerEndpoint != tc.expected {
+				t.Errorf("Expected %s, but got %s", tc.expected, env.ResourceManagerEndpoint)
+			}
+		})
+	}
+}
+
+func TestAuthenticateInEnvironment(t *testing.T) {
+	credential := *testCredential
+	credential.EnvironmentName = "china"
+
+	sdk, err := Authenticate(&credential)
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+	if sdk.Environment.Name != azure.ChinaCloud.Name {
+		t.Errorf("Expected %s, but got %s", azure.ChinaCloud.Name, sdk.Environment.Name)
+	}
+	for _, uri := range []string{sdk.ManagedClusterClient.BaseURI, sdk.VMSizeClient.BaseURI, sdk.SubscriptionsClient.BaseURI, sdk.ContainerServicesClient.BaseURI} {
+		if uri != azure.ChinaCloud.ResourceManagerEndpoint {
+			t.Errorf("Expected base URI %s, but got %s", azure.ChinaCloud.ResourceManagerEndpoint, uri)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+)
+
+// SecretPlaceholder replaces the secrets of an exported cluster. A request holding it doesn't validate until the
+// secret is set.
+const SecretPlaceholder = "<CLUSTER_CLIENT_SECRET>"
+
+// Export is a live cluster exported into the request that recreates it
+type Export struct {
+	Request *CreateClusterRequest `json:"request"`
+	// Unreproducible lists the settings of the cluster the request doesn't reproduce
+	Unreproducible []UnreproducibleField `json:"unreproducible,omitempty"`
+}
+
+// UnreproducibleField is a setting of an exported cluster the request doesn't reproduce
+type UnreproducibleField struct {
+	Field  string `json:"field"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// ExportCluster returns the request recreating the managed cluster, the reverse of NewManagedCluster. The secret of
+// its service principal is replaced with SecretPlaceholder, a Key Vault reference is kept.
+func ExportCluster(managedCluster *containerservice.ManagedCluster) *Export {
+	export := &Export{Request: &CreateClusterRequest{}}
+	info := NewClusterInfo(managedCluster)
+	if info == nil {
+		return export
+	}
+	unreproducible := func(field, value, reason string) {
+		if len(value) != 0 {
+			export.Unreproducible = append(export.Unreproducible, UnreproducibleField{Field: field, Value: value, Reason: reason})
+		}
+	}
+
+	request := export.Request
+	request.Name = info.Name
+	request.ResourceGroup = info.ResourceGroup
+	request.Location = info.Location
+	request.KubernetesVersion = info.KubernetesVersion
+	request.DNSPrefix = info.DNSPrefix
+	request.AdminUsername = info.AdminUsername
+	request.SSHPublicKeys = info.SSHPublicKeys
+	request.Tags = info.Tags
+	unreproducible("Fqdn", info.Fqdn, "assigned by Azure")
+
+	for i, pool := range info.AgentPools {
+		if i == 0 {
+			request.AgentName = pool.Name
+			request.AgentCount = pool.Count
+			request.VMSize = pool.VMSize
+			request.VnetSubnetId = pool.VnetSubnetId
+			request.OSDiskSizeGB = pool.OSDiskSizeGB
+			if len(pool.OSType) != 0 && pool.OSType != string(containerservice.Linux) {
+				unreproducible("AgentPools[0].OSType", pool.OSType, "the agents of a request run Linux")
+			}
+			continue
+		}
+		unreproducible(fmt.Sprintf("AgentPools[%d]", i), fmt.Sprintf("%s: %d x %s", pool.Name, pool.Count, pool.VMSize),
+			"a request has a single agent pool")
+	}
+	if properties := managedCluster.ManagedClusterProperties; properties != nil && properties.AgentPoolProfiles != nil {
+		for i, p := range *properties.AgentPoolProfiles {
+			field := "AgentPools[" + strconv.Itoa(i) + "]."
+			if p.DNSPrefix != nil {
+				unreproducible(field+"DNSPrefix", *p.DNSPrefix, "not supported by the request")
+			}
+			if p.Ports != nil && len(*p.Ports) != 0 {
+				unreproducible(field+"Ports", fmt.Sprint(*p.Ports), "not supported by the request")
+			}
+			unreproducible(field+"StorageProfile", string(p.StorageProfile), "not supported by the request")
+		}
+	}
+
+	if properties := managedCluster.ManagedClusterProperties; properties != nil && properties.ServicePrincipalProfile != nil {
+		profile := properties.ServicePrincipalProfile
+		identity := &ClusterIdentity{ClientId: info.ServicePrincipalClientId, ClientSecret: SecretPlaceholder}
+		if ref := profile.KeyVaultSecretRef; ref != nil {
+			identity.ClientSecret = ""
+			identity.KeyVaultSecret = &KeyVaultSecretRef{VaultId: str(ref.VaultID), SecretName: str(ref.SecretName), Version: str(ref.Version)}
+		} else {
+			unreproducible("ServicePrincipalProfile.Secret", SecretPlaceholder, "secrets aren't exported, replace the placeholder")
+		}
+		request.ClusterIdentity = identity
+	}
+	return export
+}
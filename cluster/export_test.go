@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+	"github.com/banzaicloud/azure-aks-client/utils"
+)
+
+func TestExportCluster(t *testing.T) {
+	request := CreateClusterRequest{
+		Name:              "cluster",
+		Location:          "westeurope",
+		VMSize:            "Standard_DS2_v2",
+		ResourceGroup:     "rg",
+		AgentCount:        3,
+		AgentName:         "agentpool",
+		KubernetesVersion: "1.9.6",
+		DNSPrefix:         "cluster-dns",
+		AdminUsername:     "azureuser",
+		SSHPublicKeys:     []string{"ssh-rsa AAAA"},
+		Tags:              map[string]string{"env": "prod"},
+		VnetSubnetId:      "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/aks",
+		OSDiskSizeGB:      30,
+	}
+	// NewManagedCluster points into the request, the model is built from a copy
+	model := request
+	mc := NewManagedCluster(&model, &ClusterIdentity{ClientId: "clientId", ClientSecret: "secret"})
+	mc.ID = utils.S("/subscriptions/sub/resourcegroups/rg/providers/Microsoft.ContainerService/managedClusters/cluster")
+	mc.Fqdn = utils.S("cluster-dns.hcp.westeurope.azmk8s.io")
+
+	export := ExportCluster(mc)
+	exp := request
+	exp.ClusterIdentity = &ClusterIdentity{ClientId: "clientId", ClientSecret: SecretPlaceholder}
+	if !reflect.DeepEqual(&exp, export.Request) {
+		t.Errorf("Expected request %+v, but got %+v", exp, *export.Request)
+	}
+	expFields := []string{"Fqdn", "ServicePrincipalProfile.Secret"}
+	if fields := fieldsOf(export); !reflect.DeepEqual(expFields, fields) {
+		t.Errorf("Expected unreproducible fields %v, but got %v", expFields, fields)
+	}
+	if err := export.Request.Validate(); err == nil {
+		t.Error("Expected the secret placeholder to fail validation")
+	}
+	export.Request.ClusterIdentity.ClientSecret = "secret"
+	if err := export.Request.Validate(); err != nil {
+		t.Errorf("Expected the exported request to validate with the secret set, but got %s", err)
+	}
+
+	// A second pool and a Key Vault reference
+	count := int32(1)
+	pools := append(*mc.AgentPoolProfiles, containerservice.AgentPoolProfile{Name: utils.S("gpu"), Count: &count, VMSize: "Standard_NC6"})
+	mc.AgentPoolProfiles = &pools
+	mc.ServicePrincipalProfile = &containerservice.ServicePrincipalProfile{
+		ClientID:          utils.S("clientId"),
+		KeyVaultSecretRef: &containerservice.KeyVaultSecretRef{VaultID: utils.S("vault"), SecretName: utils.S("sp")},
+	}
+	export = ExportCluster(mc)
+	expIdentity := &ClusterIdentity{ClientId: "clientId", KeyVaultSecret: &KeyVaultSecretRef{VaultId: "vault", SecretName: "sp"}}
+	if !reflect.DeepEqual(expIdentity, export.Request.ClusterIdentity) {
+		t.Errorf("Expected identity %+v, but got %+v", expIdentity, export.Request.ClusterIdentity)
+	}
+	expFields = []string{"Fqdn", "AgentPools[1]"}
+	if fields := fieldsOf(export); !reflect.DeepEqual(expFields, fields) {
+		t.Errorf("Expected unreproducible fields %v, but got %v", expFields, fields)
+	}
+
+	if export := ExportCluster(nil); export.Request == nil || len(export.Unreproducible) != 0 {
+		t.Errorf("Expected an empty export of nil, but got %+v", export)
+	}
+}
+
+func fieldsOf(export *Export) []string {
+	var fields []string
+	for _, f := range export.Unreproducible {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
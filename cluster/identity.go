@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Identity is the identity an ARM access token was issued to. It's decoded without verifying the token, so it must
+// only be used for diagnostics, never for authorization.
+type Identity struct {
+	ObjectId  string
+	AppId     string
+	TenantId  string
+	Audience  string
+	Roles     []string
+	ExpiresOn time.Time
+	// Warnings lists the inconsistencies of the token and the credentials, e.g. a tenant mismatch
+	Warnings []string
+}
+
+// DecodeIdentity returns the identity of the access token without verifying its signature
+func DecodeIdentity(accessToken string) (*Identity, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(accessToken, claims); err != nil {
+		return nil, fmt.Errorf("failed to decode access token: %s", err)
+	}
+
+	identity := &Identity{
+		ObjectId: claimString(claims, "oid"),
+		AppId:    claimString(claims, "appid"),
+		TenantId: claimString(claims, "tid"),
+		Audience: claimString(claims, "aud"),
+		Roles:    claimStrings(claims, "roles"),
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		identity.ExpiresOn = time.Unix(int64(exp), 0)
+	}
+	return identity, nil
+}
+
+// WhoAmI returns the identity of the current ARM access token, obtaining one if needed. A warning is added if the
+// token was issued by another tenant than the one in the credentials.
+func (s *Sdk) WhoAmI() (*Identity, error) {
+	if err := s.Token.EnsureFresh(); err != nil {
+		return nil, err
+	}
+	identity, err := DecodeIdentity(s.Token.OAuthToken())
+	if err != nil {
+		return nil, err
+	}
+	tenantId := s.ServicePrincipal.TenantId
+	if len(tenantId) != 0 && !strings.EqualFold(tenantId, identity.TenantId) {
+		identity.Warnings = append(identity.Warnings,
+			fmt.Sprintf("token tenant %s doesn't match the credential tenant %s", identity.TenantId, tenantId))
+	}
+	return identity, nil
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	switch v := claims[name].(type) {
+	case string:
+		return v
+	case []interface{}:
+		return strings.Join(claimStrings(claims, name), ",")
+	}
+	return ""
+}
+
+func claimStrings(claims jwt.MapClaims, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func testAccessToken(t *testing.T, tenantId string) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"oid":   "objectId",
+		"appid": "appId",
+		"tid":   tenantId,
+		"aud":   "https://management.azure.com/",
+		"roles": []string{"Reader", "Contributor"},
+		"exp":   4102444800,
+	}).SignedString([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestDecodeIdentity(t *testing.T) {
+	identity, err := DecodeIdentity(testAccessToken(t, "tenantId"))
+	if err != nil {
+		t.Fatalf("Error during decode: %s", err)
+	}
+	expected := &Identity{
+		ObjectId:  "objectId",
+		AppId:     "appId",
+		TenantId:  "tenantId",
+		Audience:  "https://management.azure.com/",
+		Roles:     []string{"Reader", "Contributor"},
+		ExpiresOn: time.Unix(4102444800, 0),
+	}
+	if !reflect.DeepEqual(expected, identity) {
+		t.Errorf("Expected %v, but got %v", expected, identity)
+	}
+
+	if _, err := DecodeIdentity("not a token"); err == nil {
+		t.Error("Expected error for malformed token")
+	}
+}
+
+func TestWhoAmITenantMismatch(t *testing.T) {
+	accessToken := testAccessToken(t, "otherTenant")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"access_token":"%s","expires_in":"3600","expires_on":"4102444800","resource":"https://management.azure.com/","token_type":"Bearer"}`, accessToken)
+	}))
+	defer server.Close()
+
+	credential := newManagedIdentityCredential("whoamiIdentity", server.URL)
+	credential.TenantId = "tenantId"
+	sdk, err := Authenticate(credential)
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+
+	identity, err := sdk.WhoAmI()
+	if err != nil {
+		t.Fatalf("Error during whoami: %s", err)
+	}
+	if identity.TenantId != "otherTenant" || len(identity.Warnings) != 1 {
+		t.Errorf("Expected tenant mismatch warning, but got %v", identity)
+	}
+}
@@ -0,0 +1,156 @@
+package cluster
+
+import (
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+	"github.com/banzaicloud/banzai-types/components/azure"
+)
+
+// ClusterInfo is the view of a managed cluster. The settings missing from the model, like the FQDN of a cluster
+// still provisioning, are empty.
+type ClusterInfo struct {
+	Id                string            `json:"id"`
+	Name              string            `json:"name"`
+	ResourceGroup     string            `json:"resourceGroup"`
+	Location          string            `json:"location"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	ProvisioningState string            `json:"provisioningState"`
+	KubernetesVersion string            `json:"kubernetesVersion"`
+	DNSPrefix         string            `json:"dnsPrefix"`
+	Fqdn              string            `json:"fqdn"`
+	AdminUsername     string            `json:"adminUsername,omitempty"`
+	// SSHPublicKeys are the public keys of the admin user
+	SSHPublicKeys []string `json:"sshPublicKeys,omitempty"`
+	// ServicePrincipalClientId is the client ID of the service principal the cluster runs with
+	ServicePrincipalClientId string          `json:"servicePrincipalClientId,omitempty"`
+	AgentPools               []AgentPoolInfo `json:"agentPools"`
+}
+
+// AgentPoolInfo is the view of an agent pool of a managed cluster
+type AgentPoolInfo struct {
+	Name         string `json:"name"`
+	Count        int    `json:"count"`
+	VMSize       string `json:"vmSize"`
+	OSDiskSizeGB int    `json:"osDiskSizeGB,omitempty"`
+	OSType       string `json:"osType,omitempty"`
+	VnetSubnetId string `json:"vnetSubnetId,omitempty"`
+}
+
+// NewClusterInfo returns the view of the managed cluster, or nil if it's nil
+func NewClusterInfo(managedCluster *containerservice.ManagedCluster) *ClusterInfo {
+	if managedCluster == nil {
+		return nil
+	}
+	info := &ClusterInfo{
+		Id:       str(managedCluster.ID),
+		Name:     str(managedCluster.Name),
+		Location: str(managedCluster.Location),
+	}
+	info.ResourceGroup = resourceGroupOf(info.Id)
+	if len(managedCluster.Tags) != 0 {
+		info.Tags = make(map[string]string, len(managedCluster.Tags))
+		for k, v := range managedCluster.Tags {
+			info.Tags[k] = str(v)
+		}
+	}
+
+	properties := managedCluster.ManagedClusterProperties
+	if properties == nil {
+		return info
+	}
+	info.ProvisioningState = str(properties.ProvisioningState)
+	info.KubernetesVersion = str(properties.KubernetesVersion)
+	info.DNSPrefix = str(properties.DNSPrefix)
+	info.Fqdn = str(properties.Fqdn)
+	if linux := properties.LinuxProfile; linux != nil {
+		info.AdminUsername = str(linux.AdminUsername)
+		if linux.SSH != nil && linux.SSH.PublicKeys != nil {
+			for _, key := range *linux.SSH.PublicKeys {
+				info.SSHPublicKeys = append(info.SSHPublicKeys, str(key.KeyData))
+			}
+		}
+	}
+	if profile := properties.ServicePrincipalProfile; profile != nil {
+		info.ServicePrincipalClientId = str(profile.ClientID)
+	}
+	if properties.AgentPoolProfiles != nil {
+		for _, p := range *properties.AgentPoolProfiles {
+			pool := AgentPoolInfo{
+				Name:         str(p.Name),
+				VMSize:       string(p.VMSize),
+				OSType:       string(p.OsType),
+				VnetSubnetId: str(p.VnetSubnetID),
+			}
+			if p.Count != nil {
+				pool.Count = int(*p.Count)
+			}
+			if p.OsDiskSizeGB != nil {
+				pool.OSDiskSizeGB = int(*p.OsDiskSizeGB)
+			}
+			info.AgentPools = append(info.AgentPools, pool)
+		}
+	}
+	return info
+}
+
+// ToValue returns the banzai-types view of the cluster, an empty one if i is nil
+func (i *ClusterInfo) ToValue() azure.Value {
+	if i == nil {
+		return azure.Value{}
+	}
+	var profiles []azure.Profile
+	for _, p := range i.AgentPools {
+		profiles = append(profiles, azure.Profile{Name: p.Name, Count: p.Count})
+	}
+	return azure.Value{
+		Id:       i.Id,
+		Location: i.Location,
+		Name:     i.Name,
+		Properties: azure.Properties{
+			ProvisioningState: i.ProvisioningState,
+			AgentPoolProfiles: profiles,
+			Fqdn:              i.Fqdn,
+		},
+	}
+}
+
+// Spec returns the effective spec of the cluster, see Spec
+func (i *ClusterInfo) Spec() Spec {
+	spec := Spec{
+		Location:          i.Location,
+		KubernetesVersion: i.KubernetesVersion,
+		DNSPrefix:         i.DNSPrefix,
+		AdminUsername:     i.AdminUsername,
+		SSHPublicKeys:     i.SSHPublicKeys,
+		Tags:              i.Tags,
+
+		ServicePrincipalClientId: i.ServicePrincipalClientId,
+	}
+	if len(i.AgentPools) != 0 {
+		spec.AgentName = i.AgentPools[0].Name
+		spec.AgentCount = i.AgentPools[0].Count
+		spec.VMSize = i.AgentPools[0].VMSize
+		spec.VnetSubnetId = i.AgentPools[0].VnetSubnetId
+		spec.OSDiskSizeGB = i.AgentPools[0].OSDiskSizeGB
+	}
+	return spec
+}
+
+// resourceGroupOf returns the resource group of an ARM resource ID, or "" if it has none
+func resourceGroupOf(id string) string {
+	parts := strings.Split(id, "/")
+	for i := 0; i+1 < len(parts); i++ {
+		if strings.EqualFold(parts[i], "resourceGroups") {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
+
+func str(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+	"github.com/banzaicloud/azure-aks-client/utils"
+	"github.com/banzaicloud/banzai-types/components/azure"
+)
+
+func TestClusterInfo(t *testing.T) {
+	count := int32(3)
+	disk := int32(30)
+	full := &containerservice.ManagedCluster{
+		ID:       utils.S("/subscriptions/sub/resourcegroups/rg/providers/Microsoft.ContainerService/managedClusters/cluster"),
+		Name:     utils.S("cluster"),
+		Location: utils.S("westeurope"),
+		Tags:     map[string]*string{"env": utils.S("prod"), "empty": nil},
+		ManagedClusterProperties: &containerservice.ManagedClusterProperties{
+			ProvisioningState: utils.S("Succeeded"),
+			KubernetesVersion: utils.S("1.9.6"),
+			DNSPrefix:         utils.S("cluster-dns"),
+			Fqdn:              utils.S("cluster-dns.hcp.westeurope.azmk8s.io"),
+			LinuxProfile: &containerservice.LinuxProfile{
+				AdminUsername: utils.S("azureuser"),
+				SSH: &containerservice.SSHConfiguration{
+					PublicKeys: &[]containerservice.SSHPublicKey{{KeyData: utils.S("ssh-rsa AAAA")}},
+				},
+			},
+			ServicePrincipalProfile: &containerservice.ServicePrincipalProfile{ClientID: utils.S("clientId"), Secret: utils.S("secret")},
+			AgentPoolProfiles: &[]containerservice.AgentPoolProfile{
+				{Name: utils.S("agentpool"), Count: &count, VMSize: "Standard_DS2_v2", OsDiskSizeGB: &disk, OsType: containerservice.Linux},
+			},
+		},
+	}
+
+	expInfo := &ClusterInfo{
+		Id:                       *full.ID,
+		Name:                     "cluster",
+		ResourceGroup:            "rg",
+		Location:                 "westeurope",
+		Tags:                     map[string]string{"env": "prod", "empty": ""},
+		ProvisioningState:        "Succeeded",
+		KubernetesVersion:        "1.9.6",
+		DNSPrefix:                "cluster-dns",
+		Fqdn:                     "cluster-dns.hcp.westeurope.azmk8s.io",
+		AdminUsername:            "azureuser",
+		SSHPublicKeys:            []string{"ssh-rsa AAAA"},
+		ServicePrincipalClientId: "clientId",
+		AgentPools:               []AgentPoolInfo{{Name: "agentpool", Count: 3, VMSize: "Standard_DS2_v2", OSDiskSizeGB: 30, OSType: "Linux"}},
+	}
+	if info := NewClusterInfo(full); !reflect.DeepEqual(expInfo, info) {
+		t.Errorf("Expected info %+v, but got %+v", expInfo, info)
+	}
+	expValue := azure.Value{
+		Id:       *full.ID,
+		Location: "westeurope",
+		Name:     "cluster",
+		Properties: azure.Properties{
+			ProvisioningState: "Succeeded",
+			AgentPoolProfiles: []azure.Profile{{Name: "agentpool", Count: 3}},
+			Fqdn:              "cluster-dns.hcp.westeurope.azmk8s.io",
+		},
+	}
+	if value := NewClusterInfo(full).ToValue(); !reflect.DeepEqual(expValue, value) {
+		t.Errorf("Expected value %+v, but got %+v", expValue, value)
+	}
+
+	cases := []struct {
+		name           string
+		managedCluster *containerservice.ManagedCluster
+	}{
+		{name: "nil", managedCluster: nil},
+		{name: "empty", managedCluster: &containerservice.ManagedCluster{}},
+		{name: "provisioning", managedCluster: &containerservice.ManagedCluster{
+			Name: utils.S("cluster"),
+			ManagedClusterProperties: &containerservice.ManagedClusterProperties{
+				ProvisioningState: utils.S("Creating"),
+				AgentPoolProfiles: &[]containerservice.AgentPoolProfile{{Name: utils.S("agentpool")}},
+			},
+		}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			value := NewClusterInfo(tc.managedCluster).ToValue()
+			if tc.managedCluster != nil && tc.managedCluster.Name != nil && value.Name != *tc.managedCluster.Name {
+				t.Errorf("Expected name %s, but got %+v", *tc.managedCluster.Name, value)
+			}
+		})
+	}
+}
@@ -7,39 +7,78 @@ import (
 	"regexp"
 )
 
+// GetManagedCluster returns the managed cluster model of the request running with the passed service principal
 func GetManagedCluster(request *CreateClusterRequest, clientId string, secret string) *containerservice.ManagedCluster {
+	return NewManagedCluster(request, &ClusterIdentity{ClientId: clientId, ClientSecret: secret})
+}
+
+// Defaults of the optional settings of a CreateClusterRequest
+const (
+	DefaultDNSPrefix     = "dnsprefix"
+	DefaultAdminUsername = "pipeline"
+	// DefaultSSHPublicKeyFile is read from ~/.ssh when the request has no SSH public keys
+	DefaultSSHPublicKeyFile = "id_rsa.pub"
+)
+
+// NewManagedCluster returns the managed cluster model of the request running with the identity
+func NewManagedCluster(request *CreateClusterRequest, identity *ClusterIdentity) *containerservice.ManagedCluster {
 	agentCount := int32(request.AgentCount)
-	agentPoolProfiles := []containerservice.AgentPoolProfile{
-		{
-			Count:  &agentCount,
-			Name:   &request.AgentName,
-			VMSize: containerservice.VMSizeTypes(request.VMSize),
-		},
+	agentPool := containerservice.AgentPoolProfile{
+		Count:  &agentCount,
+		Name:   &request.AgentName,
+		VMSize: containerservice.VMSizeTypes(request.VMSize),
+	}
+	if len(request.VnetSubnetId) != 0 {
+		agentPool.VnetSubnetID = utils.S(request.VnetSubnetId)
+	}
+	if request.OSDiskSizeGB != 0 {
+		osDiskSize := int32(request.OSDiskSizeGB)
+		agentPool.OsDiskSizeGB = &osDiskSize
+	}
+	agentPoolProfiles := []containerservice.AgentPoolProfile{agentPool}
+
+	dnsPrefix := request.DNSPrefix
+	if len(dnsPrefix) == 0 {
+		dnsPrefix = DefaultDNSPrefix
+	}
+	adminUsername := request.AdminUsername
+	if len(adminUsername) == 0 {
+		adminUsername = DefaultAdminUsername
+	}
+	sshKeys := request.SSHPublicKeys
+	if len(sshKeys) == 0 {
+		sshKeys = []string{utils.ReadPubRSA(DefaultSSHPublicKeyFile)}
+	}
+	var publicKeys []containerservice.SSHPublicKey
+	for _, key := range sshKeys {
+		publicKeys = append(publicKeys, containerservice.SSHPublicKey{KeyData: utils.S(key)})
+	}
+	var tags map[string]*string
+	if len(request.Tags) != 0 {
+		tags = make(map[string]*string, len(request.Tags))
+		for k, v := range request.Tags {
+			tags[k] = utils.S(v)
+		}
 	}
+
 	return &containerservice.ManagedCluster{
 		ManagedClusterProperties: &containerservice.ManagedClusterProperties{
 			ProvisioningState: nil,
-			DNSPrefix:         utils.S("dnsprefix"),
+			DNSPrefix:         &dnsPrefix,
 			Fqdn:              nil,
 			KubernetesVersion: &request.KubernetesVersion,
 			AgentPoolProfiles: &agentPoolProfiles,
 			LinuxProfile: &containerservice.LinuxProfile{
-				AdminUsername: utils.S("pipeline"),
+				AdminUsername: &adminUsername,
 				SSH: &containerservice.SSHConfiguration{
-					PublicKeys: &[]containerservice.SSHPublicKey{
-						{
-							KeyData: utils.S(utils.ReadPubRSA("id_rsa.pub")),
-						},
-					},
+					PublicKeys: &publicKeys,
 				},
 			},
-			ServicePrincipalProfile: &containerservice.ServicePrincipalProfile{
-				ClientID: &clientId,
-				Secret:   &secret,
-			},
+			ServicePrincipalProfile: identity.servicePrincipalProfile(),
 		},
 		Name:     &request.Name,
 		Location: &request.Location,
+		Tags:     tags,
 	}
 }
 
@@ -51,6 +90,71 @@ type CreateClusterRequest struct {
 	AgentCount        int
 	AgentName         string
 	KubernetesVersion string
+	// ClusterIdentity is the service principal the cluster runs with, it should have only the permissions the
+	// cluster needs. If nil, the cluster identity of the client is used.
+	ClusterIdentity *ClusterIdentity
+	// AllowManagementIdentity lets the cluster run with the management credential of the client if no cluster
+	// identity is set. Anyone with access to the nodes can read that credential.
+	AllowManagementIdentity bool
+	// DNSPrefix of the cluster's FQDN, DefaultDNSPrefix if empty
+	DNSPrefix string
+	// AdminUsername of the nodes, DefaultAdminUsername if empty
+	AdminUsername string
+	// SSHPublicKeys of the admin user, the key in DefaultSSHPublicKeyFile if empty
+	SSHPublicKeys []string
+	Tags          map[string]string
+	// VnetSubnetId is the ID of the subnet the agents are placed in, a new virtual network is created if empty
+	VnetSubnetId string
+	// OSDiskSizeGB of the agents, the default of the VM size if zero
+	OSDiskSizeGB int
+	// AllowUpdate lets CreateUpdateCluster update an existing cluster whose spec differs from the request. Without
+	// it, such a cluster fails the request with a conflict.
+	AllowUpdate bool
+}
+
+// DeepCopy returns a copy of the request sharing no maps, slices or pointers with it
+func (c *CreateClusterRequest) DeepCopy() *CreateClusterRequest {
+	if c == nil {
+		return nil
+	}
+	copied := *c
+	if c.ClusterIdentity != nil {
+		identity := *c.ClusterIdentity
+		if identity.KeyVaultSecret != nil {
+			ref := *identity.KeyVaultSecret
+			identity.KeyVaultSecret = &ref
+		}
+		copied.ClusterIdentity = &identity
+	}
+	if c.SSHPublicKeys != nil {
+		copied.SSHPublicKeys = append([]string(nil), c.SSHPublicKeys...)
+	}
+	if c.Tags != nil {
+		copied.Tags = make(map[string]string, len(c.Tags))
+		for k, v := range c.Tags {
+			copied.Tags[k] = v
+		}
+	}
+	return &copied
+}
+
+// servicePrincipalProfile returns the ServicePrincipalProfile of the identity
+func (i *ClusterIdentity) servicePrincipalProfile() *containerservice.ServicePrincipalProfile {
+	profile := &containerservice.ServicePrincipalProfile{
+		ClientID: utils.S(i.ClientId),
+	}
+	if ref := i.KeyVaultSecret; ref != nil {
+		profile.KeyVaultSecretRef = &containerservice.KeyVaultSecretRef{
+			VaultID:    utils.S(ref.VaultId),
+			SecretName: utils.S(ref.SecretName),
+		}
+		if len(ref.Version) != 0 {
+			profile.KeyVaultSecretRef.Version = utils.S(ref.Version)
+		}
+	} else {
+		profile.Secret = utils.S(i.ClientSecret)
+	}
+	return profile
 }
 
 func (c CreateClusterRequest) Validate() error {
@@ -63,6 +167,11 @@ func (c CreateClusterRequest) Validate() error {
 	if isMatch, _ := regexp.MatchString(RegexpForName, c.Name); !isMatch {
 		return constants.ErrorAzureClusterNameRegexp
 	}
+	if c.ClusterIdentity != nil {
+		if err := c.ClusterIdentity.Validate(); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -0,0 +1,303 @@
+package cluster
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/banzaicloud/azure-aks-client/utils"
+	"github.com/dimchansky/utfbom"
+)
+
+// AzureAuthLocation is the environment variable pointing to an SDK auth file (az ad sp create-for-rbac --sdk-auth)
+const AzureAuthLocation = "AZURE_AUTH_LOCATION"
+
+// Environment variables of the named-profile config file
+const (
+	AKSProfilesFile = "AKS_PROFILES_FILE"
+	AKSProfile      = "AKS_PROFILE"
+)
+
+// DefaultProfile is the profile used when no profile name is set
+const DefaultProfile = "default"
+
+// Names of the built-in credential providers
+const (
+	ProviderExplicit    = "explicit"
+	ProviderEnvironment = "environment"
+	ProviderAuthFile    = "authfile"
+	ProviderProfile     = "profile"
+)
+
+// ErrNoCredentials is returned by a CredentialProvider that has no credentials to offer
+var ErrNoCredentials = utils.NewErr("no credentials found")
+
+// CredentialProvider resolves the credentials used by Authenticate
+type CredentialProvider interface {
+	// Name identifies the source of the credentials
+	Name() string
+	// Retrieve returns the credentials, or ErrNoCredentials if the source has none
+	Retrieve() (*AKSCredential, error)
+}
+
+// credentialFile is the format of an SDK auth file and of a profile in the profiles file
+type credentialFile struct {
+	ClientId                string `json:"clientId"`
+	ClientSecret            string `json:"clientSecret"`
+	SubscriptionId          string `json:"subscriptionId"`
+	TenantId                string `json:"tenantId"`
+	AuthMethod              string `json:"authMethod,omitempty"`
+	CertificatePath         string `json:"certificatePath,omitempty"`
+	CertificatePassword     string `json:"certificatePassword,omitempty"`
+	ManagedIdentityEndpoint string `json:"managedIdentityEndpoint,omitempty"`
+	TokenCachePath          string `json:"tokenCachePath,omitempty"`
+	Environment             string `json:"environment,omitempty"`
+	EnvironmentFile         string `json:"environmentFile,omitempty"`
+	ClusterClientId         string `json:"clusterClientId,omitempty"`
+	ClusterClientSecret     string `json:"clusterClientSecret,omitempty"`
+	// the endpoints of the SDK auth file select the Azure cloud, see environmentOfEndpoints
+	ActiveDirectoryEndpointUrl string `json:"activeDirectoryEndpointUrl,omitempty"`
+	ResourceManagerEndpointUrl string `json:"resourceManagerEndpointUrl,omitempty"`
+}
+
+func (f *credentialFile) toCredential() (*AKSCredential, error) {
+	credential := &AKSCredential{
+		ClientId:                f.ClientId,
+		ClientSecret:            f.ClientSecret,
+		SubscriptionId:          f.SubscriptionId,
+		TenantId:                f.TenantId,
+		AuthMethod:              AuthMethod(f.AuthMethod),
+		CertificatePath:         f.CertificatePath,
+		CertificatePassword:     f.CertificatePassword,
+		ManagedIdentityEndpoint: f.ManagedIdentityEndpoint,
+		TokenCachePath:          f.TokenCachePath,
+		EnvironmentName:         f.Environment,
+		EnvironmentFile:         f.EnvironmentFile,
+		ClusterIdentity:         newClusterIdentity(f.ClusterClientId, f.ClusterClientSecret),
+	}
+	if len(credential.EnvironmentName) == 0 && len(credential.EnvironmentFile) == 0 {
+		name, err := environmentOfEndpoints(f.ResourceManagerEndpointUrl, f.ActiveDirectoryEndpointUrl)
+		if err != nil {
+			return nil, err
+		}
+		credential.EnvironmentName = name
+	}
+	return credential, nil
+}
+
+// newClusterIdentity returns nil if neither the client ID nor the secret is set
+func newClusterIdentity(clientId, clientSecret string) *ClusterIdentity {
+	if len(clientId) == 0 && len(clientSecret) == 0 {
+		return nil
+	}
+	return &ClusterIdentity{ClientId: clientId, ClientSecret: clientSecret}
+}
+
+// StaticProvider provides explicitly passed credentials
+type StaticProvider struct {
+	Credential *AKSCredential
+}
+
+func (p *StaticProvider) Name() string {
+	return ProviderExplicit
+}
+
+func (p *StaticProvider) Retrieve() (*AKSCredential, error) {
+	if p.Credential == nil {
+		return nil, ErrNoCredentials
+	}
+	return p.Credential, nil
+}
+
+// EnvProvider provides credentials from the AZURE_CLIENT_ID, AZURE_CLIENT_SECRET, AZURE_SUBSCRIPTION_ID and
+// AZURE_TENANT_ID environment variables. Certificate authentication uses AZURE_CERTIFICATE_PATH,
+// AZURE_CERTIFICATE_PASSWORD, AZURE_CLUSTER_CLIENT_ID and AZURE_CLUSTER_CLIENT_SECRET instead of AZURE_CLIENT_SECRET.
+// Other auth methods are selected by AZURE_AUTH_METHOD, see AuthMethod.
+type EnvProvider struct{}
+
+func (p *EnvProvider) Name() string {
+	return ProviderEnvironment
+}
+
+func (p *EnvProvider) Retrieve() (*AKSCredential, error) {
+	credential := &AKSCredential{
+		ClientId:                os.Getenv(AzureClientId),
+		ClientSecret:            os.Getenv(AzureClientSecret),
+		SubscriptionId:          os.Getenv(AzureSubscriptionId),
+		TenantId:                os.Getenv(AzureTenantId),
+		CertificatePath:         os.Getenv(AzureCertificatePath),
+		CertificatePassword:     os.Getenv(AzureCertificatePassword),
+		ClusterIdentity:         newClusterIdentity(os.Getenv(AzureClusterClientId), os.Getenv(AzureClusterClientSecret)),
+		AuthMethod:              AuthMethod(os.Getenv(AzureAuthMethod)),
+		ManagedIdentityEndpoint: os.Getenv(AzureManagedIdentityEndpoint),
+		TokenCachePath:          os.Getenv(AzureTokenCachePath),
+		EnvironmentName:         os.Getenv(AzureEnvironment),
+		EnvironmentFile:         os.Getenv(AzureEnvironmentFile),
+	}
+	if !credential.complete() {
+		// e.g. only AZURE_ENVIRONMENT is set, the credentials may come from the next provider
+		return nil, ErrNoCredentials
+	}
+	return credential, nil
+}
+
+// AuthFileProvider provides credentials from an SDK auth file. Path defaults to the AZURE_AUTH_LOCATION environment
+// variable.
+type AuthFileProvider struct {
+	Path string
+}
+
+func (p *AuthFileProvider) Name() string {
+	return ProviderAuthFile
+}
+
+func (p *AuthFileProvider) Retrieve() (*AKSCredential, error) {
+	path := p.Path
+	if len(path) == 0 {
+		path = os.Getenv(AzureAuthLocation)
+	}
+	if len(path) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	f := credentialFile{}
+	if err := readJSONFile(path, &f); err != nil {
+		return nil, utils.NewErr(fmt.Sprintf("error reading auth file %s: %s", path, err))
+	}
+	credential, err := f.toCredential()
+	if err != nil {
+		return nil, utils.NewErr(fmt.Sprintf("error reading auth file %s: %s", path, err))
+	}
+	return credential, nil
+}
+
+// ProfileProvider provides credentials from a named profile of a JSON file that maps profile names to credentials
+// in the auth file format. Path defaults to the AKS_PROFILES_FILE environment variable, then to
+// ~/.azure/aks-profiles.json, Profile defaults to the AKS_PROFILE environment variable, then to "default".
+type ProfileProvider struct {
+	Path    string
+	Profile string
+}
+
+func (p *ProfileProvider) Name() string {
+	return ProviderProfile
+}
+
+func (p *ProfileProvider) Retrieve() (*AKSCredential, error) {
+	path := p.Path
+	if len(path) == 0 {
+		path = os.Getenv(AKSProfilesFile)
+	}
+	if len(path) == 0 {
+		path = filepath.Join(os.Getenv("HOME"), ".azure", "aks-profiles.json")
+	}
+
+	profile := p.Profile
+	if len(profile) == 0 {
+		profile = os.Getenv(AKSProfile)
+	}
+	if len(profile) == 0 {
+		profile = DefaultProfile
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, ErrNoCredentials
+	}
+
+	profiles := map[string]credentialFile{}
+	if err := readJSONFile(path, &profiles); err != nil {
+		return nil, utils.NewErr(fmt.Sprintf("error reading profiles file %s: %s", path, err))
+	}
+	f, ok := profiles[profile]
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	credential, err := f.toCredential()
+	if err != nil {
+		return nil, utils.NewErr(fmt.Sprintf("error reading profile %s of %s: %s", profile, path, err))
+	}
+	return credential, nil
+}
+
+// ChainProvider tries its providers in order and returns the credentials of the first one that has any
+type ChainProvider struct {
+	Providers []CredentialProvider
+	source    string
+}
+
+// NewChainProvider creates a chain of the passed providers
+func NewChainProvider(providers ...CredentialProvider) *ChainProvider {
+	return &ChainProvider{Providers: providers}
+}
+
+// DefaultCredentialChain returns the chain used by Authenticate: explicit credentials, environment variables,
+// SDK auth file, then the named profile
+func DefaultCredentialChain(credentials *AKSCredential) *ChainProvider {
+	return NewChainProvider(
+		&StaticProvider{Credential: credentials},
+		&EnvProvider{},
+		&AuthFileProvider{},
+		&ProfileProvider{},
+	)
+}
+
+// NewChainProviderFromNames creates a chain of the built-in providers in the passed order, e.g. from a comma
+// separated command line flag
+func NewChainProviderFromNames(names []string, credentials *AKSCredential) (*ChainProvider, error) {
+	var providers []CredentialProvider
+	for _, name := range names {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case ProviderExplicit:
+			providers = append(providers, &StaticProvider{Credential: credentials})
+		case ProviderEnvironment:
+			providers = append(providers, &EnvProvider{})
+		case ProviderAuthFile:
+			providers = append(providers, &AuthFileProvider{})
+		case ProviderProfile:
+			providers = append(providers, &ProfileProvider{})
+		default:
+			return nil, utils.NewErr(fmt.Sprintf("unknown credential provider: %s", name))
+		}
+	}
+	return NewChainProvider(providers...), nil
+}
+
+// Name returns the name of the provider the credentials were retrieved from, or "chain" before retrieval
+func (c *ChainProvider) Name() string {
+	if len(c.source) == 0 {
+		return "chain"
+	}
+	return c.source
+}
+
+func (c *ChainProvider) Retrieve() (*AKSCredential, error) {
+	for _, p := range c.Providers {
+		credential, err := p.Retrieve()
+		if err == ErrNoCredentials {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		c.source = p.Name()
+		return credential, nil
+	}
+	return nil, ErrNoCredentials
+}
+
+// readJSONFile reads a JSON file that may start with a byte order mark
+func readJSONFile(path string, v interface{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(utfbom.SkipOnly(f))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
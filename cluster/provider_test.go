@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+var testCredential = &AKSCredential{
+	ClientId:       "clientId",
+	ClientSecret:   "clientSecret",
+	SubscriptionId: "subscriptionId",
+	TenantId:       "tenantId",
+}
+
+const testCredentialJSON = `{"clientId":"clientId","clientSecret":"clientSecret","subscriptionId":"subscriptionId","tenantId":"tenantId"}`
+
+func TestCredentialChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authFile := filepath.Join(dir, "auth.json")
+	ioutil.WriteFile(authFile, []byte("\xef\xbb\xbf"+testCredentialJSON), 0600)
+	profilesFile := filepath.Join(dir, "profiles.json")
+	ioutil.WriteFile(profilesFile, []byte(`{"prod":`+testCredentialJSON+`}`), 0600)
+
+	for _, env := range []string{AzureClientId, AzureClientSecret, AzureSubscriptionId, AzureTenantId, AzureEnvironment, AzureAuthLocation, AKSProfilesFile, AKSProfile} {
+		defer os.Setenv(env, os.Getenv(env))
+		os.Unsetenv(env)
+	}
+
+	cases := []struct {
+		name      string
+		env       map[string]string
+		providers []CredentialProvider
+		expSource string
+		expError  error
+	}{
+		{name: "explicit", providers: []CredentialProvider{&StaticProvider{Credential: testCredential}, &EnvProvider{}}, expSource: ProviderExplicit},
+		{name: "auth file", providers: []CredentialProvider{&StaticProvider{}, &EnvProvider{}, &AuthFileProvider{Path: authFile}}, expSource: ProviderAuthFile},
+		{
+			name:      "partial environment",
+			env:       map[string]string{AzureEnvironment: "public", AzureTenantId: "tenantId"},
+			providers: []CredentialProvider{&EnvProvider{}, &AuthFileProvider{Path: authFile}},
+			expSource: ProviderAuthFile,
+		},
+		{name: "profile", providers: []CredentialProvider{&EnvProvider{}, &ProfileProvider{Path: profilesFile, Profile: "prod"}}, expSource: ProviderProfile},
+		{name: "missing profile", providers: []CredentialProvider{&ProfileProvider{Path: profilesFile}}, expError: ErrNoCredentials},
+		{name: "none", providers: []CredentialProvider{&StaticProvider{}, &EnvProvider{}, &AuthFileProvider{}}, expError: ErrNoCredentials},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			chain := NewChainProvider(tc.providers...)
+			credential, err := chain.Retrieve()
+			if err != tc.expError {
+				t.Fatalf("Expected error %v, but got %v", tc.expError, err)
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(testCredential, credential) {
+				t.Errorf("Expected credential %v, but got %v", testCredential, credential)
+			}
+			if chain.Name() != tc.expSource {
+				t.Errorf("Expected source %s, but got %s", tc.expSource, chain.Name())
+			}
+		})
+	}
+}
+
+func TestAuthFileEndpoints(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	authFile := filepath.Join(dir, "auth.json")
+	china := `{"clientId":"clientId","activeDirectoryEndpointUrl":"https://login.chinacloudapi.cn","resourceManagerEndpointUrl":"https://management.chinacloudapi.cn/"}`
+	ioutil.WriteFile(authFile, []byte(china), 0600)
+	credential, err := (&AuthFileProvider{Path: authFile}).Retrieve()
+	if err != nil {
+		t.Fatalf("Error during reading auth file: %s", err)
+	}
+	if credential.EnvironmentName != azure.ChinaCloud.Name {
+		t.Errorf("Expected %s, but got %q", azure.ChinaCloud.Name, credential.EnvironmentName)
+	}
+
+	stack := `{"clientId":"clientId","resourceManagerEndpointUrl":"https://management.local.azurestack.external/"}`
+	ioutil.WriteFile(authFile, []byte(stack), 0600)
+	if _, err := (&AuthFileProvider{Path: authFile}).Retrieve(); err == nil {
+		t.Error("Expected error for the endpoints of an unknown cloud")
+	}
+}
+
+func TestNewChainProviderFromNames(t *testing.T) {
+	chain, err := NewChainProviderFromNames([]string{"profile", " Environment"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chain.Providers) != 2 || chain.Providers[0].Name() != ProviderProfile || chain.Providers[1].Name() != ProviderEnvironment {
+		t.Errorf("Unexpected providers: %v", chain.Providers)
+	}
+
+	if _, err := NewChainProviderFromNames([]string{"unknown"}, nil); err == nil {
+		t.Error("Expected error for unknown provider")
+	}
+}
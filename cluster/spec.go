@@ -0,0 +1,143 @@
+package cluster
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+)
+
+// Spec is the effective spec of a cluster: the settings of a CreateClusterRequest that can be read back from an
+// existing cluster
+type Spec struct {
+	Location          string
+	KubernetesVersion string
+	AgentName         string
+	AgentCount        int
+	VMSize            string
+	DNSPrefix         string
+	AdminUsername     string
+	// SSHPublicKeys aren't compared if none are requested, the default key is read from a file of the host
+	SSHPublicKeys []string
+	Tags          map[string]string
+	VnetSubnetId  string
+	// OSDiskSizeGB isn't compared if zero is requested, the default of the VM size is set by Azure
+	OSDiskSizeGB int
+	// ServicePrincipalClientId isn't compared if the request has no ClusterIdentity, the identity of the client is
+	// only resolved when the request is sent. The secret of the service principal can't be read back.
+	ServicePrincipalClientId string
+}
+
+// SpecDifference is a setting of an existing cluster that differs from the requested one
+type SpecDifference struct {
+	Field     string
+	Existing  string
+	Requested string
+}
+
+// Spec returns the spec the request creates
+func (c *CreateClusterRequest) Spec() Spec {
+	spec := Spec{
+		Location:          c.Location,
+		KubernetesVersion: c.KubernetesVersion,
+		AgentName:         c.AgentName,
+		AgentCount:        c.AgentCount,
+		VMSize:            c.VMSize,
+		DNSPrefix:         c.DNSPrefix,
+		AdminUsername:     c.AdminUsername,
+		SSHPublicKeys:     c.SSHPublicKeys,
+		Tags:              c.Tags,
+		VnetSubnetId:      c.VnetSubnetId,
+		OSDiskSizeGB:      c.OSDiskSizeGB,
+	}
+	if c.ClusterIdentity != nil {
+		spec.ServicePrincipalClientId = c.ClusterIdentity.ClientId
+	}
+	if len(spec.DNSPrefix) == 0 {
+		spec.DNSPrefix = DefaultDNSPrefix
+	}
+	if len(spec.AdminUsername) == 0 {
+		spec.AdminUsername = DefaultAdminUsername
+	}
+	return spec
+}
+
+// SpecOf returns the spec of an existing cluster, the settings missing from the model are empty
+func SpecOf(managedCluster *containerservice.ManagedCluster) Spec {
+	if managedCluster == nil {
+		return Spec{}
+	}
+	return NewClusterInfo(managedCluster).Spec()
+}
+
+// Diff returns the differences of the requested spec from s. Names are compared case-insensitively and locations
+// without spaces, as ARM returns "westeurope" for "West Europe".
+func (s Spec) Diff(requested Spec) []SpecDifference {
+	var differences []SpecDifference
+	add := func(field, existing, requested string, equal bool) {
+		if !equal {
+			differences = append(differences, SpecDifference{Field: field, Existing: existing, Requested: requested})
+		}
+	}
+	add("Location", s.Location, requested.Location, NormalizeLocation(s.Location) == NormalizeLocation(requested.Location))
+	add("KubernetesVersion", s.KubernetesVersion, requested.KubernetesVersion, s.KubernetesVersion == requested.KubernetesVersion)
+	add("AgentName", s.AgentName, requested.AgentName, strings.EqualFold(s.AgentName, requested.AgentName))
+	add("AgentCount", strconv.Itoa(s.AgentCount), strconv.Itoa(requested.AgentCount), s.AgentCount == requested.AgentCount)
+	add("VMSize", s.VMSize, requested.VMSize, strings.EqualFold(s.VMSize, requested.VMSize))
+	add("DNSPrefix", s.DNSPrefix, requested.DNSPrefix, s.DNSPrefix == requested.DNSPrefix)
+	add("AdminUsername", s.AdminUsername, requested.AdminUsername, s.AdminUsername == requested.AdminUsername)
+	if len(requested.SSHPublicKeys) != 0 {
+		add("SSHPublicKeys", strings.Join(s.SSHPublicKeys, ", "), strings.Join(requested.SSHPublicKeys, ", "),
+			equalKeys(s.SSHPublicKeys, requested.SSHPublicKeys))
+	}
+	add("Tags", formatTags(s.Tags), formatTags(requested.Tags), equalTags(s.Tags, requested.Tags))
+	add("VnetSubnetId", s.VnetSubnetId, requested.VnetSubnetId, strings.EqualFold(s.VnetSubnetId, requested.VnetSubnetId))
+	if requested.OSDiskSizeGB != 0 {
+		add("OSDiskSizeGB", strconv.Itoa(s.OSDiskSizeGB), strconv.Itoa(requested.OSDiskSizeGB), s.OSDiskSizeGB == requested.OSDiskSizeGB)
+	}
+	if len(requested.ServicePrincipalClientId) != 0 {
+		add("ServicePrincipalClientId", s.ServicePrincipalClientId, requested.ServicePrincipalClientId,
+			strings.EqualFold(s.ServicePrincipalClientId, requested.ServicePrincipalClientId))
+	}
+	return differences
+}
+
+func equalKeys(existing, requested []string) bool {
+	if len(existing) != len(requested) {
+		return false
+	}
+	for i := range existing {
+		if strings.TrimSpace(existing[i]) != strings.TrimSpace(requested[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalTags(existing, requested map[string]string) bool {
+	if len(existing) != len(requested) {
+		return false
+	}
+	for k, v := range requested {
+		if current, ok := existing[k]; !ok || current != v {
+			return false
+		}
+	}
+	return true
+}
+
+// formatTags returns the tags sorted by key, e.g. "env=prod, team=infra"
+func formatTags(tags map[string]string) string {
+	var pairs []string
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+// NormalizeLocation returns the location in lower case without spaces, e.g. westeurope for "West Europe"
+func NormalizeLocation(location string) string {
+	return strings.ToLower(strings.Replace(location, " ", "", -1))
+}
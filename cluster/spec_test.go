@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
+)
+
+func TestSpecDiff(t *testing.T) {
+	request := &CreateClusterRequest{
+		Name:              "cluster",
+		Location:          "West Europe",
+		VMSize:            "Standard_DS2_v2",
+		AgentCount:        3,
+		AgentName:         "agentpool",
+		KubernetesVersion: "1.9.6",
+	}
+	// the model points into its request
+	created := *request
+	existing := NewManagedCluster(&created, &ClusterIdentity{ClientId: "clientId", ClientSecret: "secret"})
+	existing.Location = &[]string{"westeurope"}[0]
+	(*existing.AgentPoolProfiles)[0].VMSize = containerservice.VMSizeTypes("standard_ds2_v2")
+
+	if differences := SpecOf(existing).Diff(request.Spec()); len(differences) != 0 {
+		t.Errorf("Expected matching specs, but got %v", differences)
+	}
+
+	request.KubernetesVersion = "1.10.3"
+	exp := []SpecDifference{{Field: "KubernetesVersion", Existing: "1.9.6", Requested: "1.10.3"}}
+	if differences := SpecOf(existing).Diff(request.Spec()); !reflect.DeepEqual(exp, differences) {
+		t.Errorf("Expected differences %v, but got %v", exp, differences)
+	}
+
+	request.KubernetesVersion = "1.9.6"
+	request.Tags = map[string]string{"env": "prod"}
+	exp = []SpecDifference{{Field: "Tags", Existing: "", Requested: "env=prod"}}
+	if differences := SpecOf(existing).Diff(request.Spec()); !reflect.DeepEqual(exp, differences) {
+		t.Errorf("Expected differences %v, but got %v", exp, differences)
+	}
+
+	request.Tags = nil
+	request.VnetSubnetId = "subnet"
+	request.OSDiskSizeGB = 100
+	exp = []SpecDifference{{Field: "VnetSubnetId", Existing: "", Requested: "subnet"}, {Field: "OSDiskSizeGB", Existing: "0", Requested: "100"}}
+	if differences := SpecOf(existing).Diff(request.Spec()); !reflect.DeepEqual(exp, differences) {
+		t.Errorf("Expected differences %v, but got %v", exp, differences)
+	}
+
+	if spec := SpecOf(nil); !reflect.DeepEqual(spec, Spec{}) {
+		t.Errorf("Expected empty spec, but got %v", spec)
+	}
+}
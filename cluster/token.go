@@ -0,0 +1,228 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+)
+
+// DefaultRefreshWithin is how long before expiry a token is refreshed
+const DefaultRefreshWithin = 5 * time.Minute
+
+// refreshRetryInterval is the wait of the background refresh after a failed attempt
+const refreshRetryInterval = 30 * time.Second
+
+// CachedToken is the token provider of the ARM calls. It serves tokens from a TokenCache and refreshes them through
+// the underlying adal token when they're about to expire, optionally in the background.
+type CachedToken struct {
+	key           TokenCacheKey
+	source        *adal.ServicePrincipalToken
+	refreshWithin time.Duration
+
+	lock    sync.RWMutex
+	cache   TokenCache
+	token   adal.Token
+	onError func(error)
+
+	refreshLock sync.Mutex
+	updated     chan struct{}
+	stop        chan struct{}
+}
+
+// NewCachedToken creates a CachedToken of key, which is refreshed by source and stored in cache
+func NewCachedToken(key TokenCacheKey, source *adal.ServicePrincipalToken, cache TokenCache) *CachedToken {
+	t := &CachedToken{
+		key:           key,
+		source:        source,
+		refreshWithin: DefaultRefreshWithin,
+	}
+	t.SetCache(cache)
+	return t
+}
+
+// SetCache replaces the cache of the token and takes the cached token if it's still valid
+func (t *CachedToken) SetCache(cache TokenCache) {
+	t.lock.Lock()
+	t.cache = cache
+	t.lock.Unlock()
+
+	if !t.loadCached() {
+		if token := t.source.Token(); !token.IsZero() {
+			t.store(token)
+		}
+	}
+}
+
+// OnRefreshError sets the handler of the refresh and cache failures that can't be returned to a caller
+func (t *CachedToken) OnRefreshError(handler func(error)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.onError = handler
+}
+
+// Token returns a copy of the current token
+func (t *CachedToken) Token() adal.Token {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.token
+}
+
+// OAuthToken implements the adal.OAuthTokenProvider interface
+func (t *CachedToken) OAuthToken() string {
+	token := t.Token()
+	return token.OAuthToken()
+}
+
+// EnsureFresh refreshes the token if it expires within the refresh window. It implements the adal.Refresher
+// interface.
+func (t *CachedToken) EnsureFresh() error {
+	if !t.expiring() {
+		return nil
+	}
+	t.refreshLock.Lock()
+	defer t.refreshLock.Unlock()
+	if !t.expiring() || t.loadCached() {
+		return nil
+	}
+	return t.refresh(t.source.Refresh)
+}
+
+// Refresh obtains a new token from Azure Active Directory
+func (t *CachedToken) Refresh() error {
+	t.refreshLock.Lock()
+	defer t.refreshLock.Unlock()
+	return t.refresh(t.source.Refresh)
+}
+
+// RefreshExchange obtains a new token for resource
+func (t *CachedToken) RefreshExchange(resource string) error {
+	t.refreshLock.Lock()
+	defer t.refreshLock.Unlock()
+	return t.refresh(func() error {
+		return t.source.RefreshExchange(resource)
+	})
+}
+
+// StartBackgroundRefresh refreshes the token before it expires until Stop is called. Nothing is refreshed before
+// the first token is obtained, failures are reported to the OnRefreshError handler.
+func (t *CachedToken) StartBackgroundRefresh() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.stop != nil {
+		return
+	}
+	t.stop = make(chan struct{})
+	t.updated = make(chan struct{}, 1)
+	go t.refreshLoop(t.stop, t.updated)
+}
+
+// Stop stops the background refresh
+func (t *CachedToken) Stop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+}
+
+func (t *CachedToken) refreshLoop(stop, updated chan struct{}) {
+	for {
+		var timer *time.Timer
+		var wait <-chan time.Time
+		if token := t.Token(); !token.IsZero() {
+			timer = time.NewTimer(time.Until(token.Expires().Add(-t.refreshWithin)))
+			wait = timer.C
+		}
+		select {
+		case <-stop:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-updated:
+			if timer != nil {
+				timer.Stop()
+			}
+		case <-wait:
+			if err := t.EnsureFresh(); err != nil {
+				t.reportError(err)
+				select {
+				case <-stop:
+					return
+				case <-time.After(refreshRetryInterval):
+				}
+			}
+		}
+	}
+}
+
+func (t *CachedToken) expiring() bool {
+	token := t.Token()
+	return token.IsZero() || token.WillExpireIn(t.refreshWithin)
+}
+
+// refresh runs the refresh of the source and stores the new token
+func (t *CachedToken) refresh(refresh func() error) error {
+	if err := refresh(); err != nil {
+		return err
+	}
+	t.store(t.source.Token())
+	return nil
+}
+
+// loadCached takes the cached token if it doesn't expire within the refresh window, another client or process may
+// have refreshed it already
+func (t *CachedToken) loadCached() bool {
+	t.lock.RLock()
+	cache := t.cache
+	t.lock.RUnlock()
+	if cache == nil {
+		return false
+	}
+	token, err := cache.Load(t.key)
+	if err != nil {
+		t.reportError(err)
+		return false
+	}
+	if token == nil || token.IsZero() || token.WillExpireIn(t.refreshWithin) {
+		return false
+	}
+	t.setToken(*token)
+	return true
+}
+
+// store sets the token and saves it to the cache
+func (t *CachedToken) store(token adal.Token) {
+	t.setToken(token)
+	t.lock.RLock()
+	cache := t.cache
+	t.lock.RUnlock()
+	if cache != nil {
+		if err := cache.Save(t.key, token); err != nil {
+			t.reportError(err)
+		}
+	}
+}
+
+func (t *CachedToken) setToken(token adal.Token) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.token = token
+	if t.updated != nil {
+		select {
+		case t.updated <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *CachedToken) reportError(err error) {
+	t.lock.RLock()
+	handler := t.onError
+	t.lock.RUnlock()
+	if handler != nil {
+		handler(err)
+	}
+}
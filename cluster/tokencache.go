@@ -0,0 +1,235 @@
+package cluster
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"golang.org/x/crypto/scrypt"
+)
+
+// TokenCacheKey identifies a cached token
+type TokenCacheKey struct {
+	TenantId string
+	ClientId string
+	Resource string
+	// Credential is a hash of the auth method and the credential material the token was obtained with, so a client
+	// with the same client ID but another secret, certificate or endpoint doesn't get the token of another one
+	Credential string
+}
+
+func (k TokenCacheKey) String() string {
+	return k.TenantId + "/" + k.ClientId + "/" + k.Resource + "/" + k.Credential
+}
+
+// TokenCache stores the tokens of the ARM calls, so they can be reused by later clients instead of requesting new
+// ones from Azure Active Directory
+type TokenCache interface {
+	// Load returns the cached token of the key, or nil if there's none
+	Load(key TokenCacheKey) (*adal.Token, error)
+	Save(key TokenCacheKey, token adal.Token) error
+}
+
+// DefaultTokenCache is the in-memory cache shared by the clients of the process
+var DefaultTokenCache TokenCache = NewMemoryTokenCache()
+
+// MemoryTokenCache is a TokenCache living as long as the process
+type MemoryTokenCache struct {
+	lock   sync.RWMutex
+	tokens map[TokenCacheKey]adal.Token
+}
+
+func NewMemoryTokenCache() *MemoryTokenCache {
+	return &MemoryTokenCache{tokens: make(map[TokenCacheKey]adal.Token)}
+}
+
+func (c *MemoryTokenCache) Load(key TokenCacheKey) (*adal.Token, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if token, ok := c.tokens[key]; ok {
+		return &token, nil
+	}
+	return nil, nil
+}
+
+func (c *MemoryTokenCache) Save(key TokenCacheKey, token adal.Token) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for k, t := range c.tokens {
+		if t.IsExpired() {
+			delete(c.tokens, k)
+		}
+	}
+	c.tokens[key] = token
+	return nil
+}
+
+// FileTokenCache is a TokenCache persisted in a file readable only by the owner. The content is encrypted with
+// AES-GCM using a key derived from the passphrase by scrypt, with a random salt kept in the file, so the tokens can
+// be shared by short-lived processes.
+type FileTokenCache struct {
+	path       string
+	passphrase []byte
+	lock       sync.Mutex
+	// salt and aead of the file, derived again when another process created the file with another salt
+	salt []byte
+	aead cipher.AEAD
+}
+
+// tokenCacheMagic starts a token cache file, followed by the salt of its key and the sealed tokens. Files without it
+// were written by an earlier version with an unsalted key, they are replaced on the next save.
+var tokenCacheMagic = []byte("akstc1")
+
+// scrypt parameters of the key of a token cache file
+const (
+	tokenCacheSaltSize = 16
+	scryptN            = 1 << 15
+	scryptR            = 8
+	scryptP            = 1
+)
+
+// NewFileTokenCache creates a FileTokenCache at path, encrypted with passphrase
+func NewFileTokenCache(path string, passphrase []byte) (*FileTokenCache, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("token cache passphrase is empty")
+	}
+	return &FileTokenCache{path: path, passphrase: append([]byte(nil), passphrase...)}, nil
+}
+
+// cipher returns the AEAD of the key derived from the passphrase and salt
+func (c *FileTokenCache) cipher(salt []byte) (cipher.AEAD, error) {
+	if c.aead != nil && bytes.Equal(c.salt, salt) {
+		return c.aead, nil
+	}
+	key, err := scrypt.Key(c.passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c.salt, c.aead = salt, aead
+	return aead, nil
+}
+
+func (c *FileTokenCache) Load(key TokenCacheKey) (*adal.Token, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tokens, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+	if token, ok := tokens[key.String()]; ok {
+		return &token, nil
+	}
+	return nil, nil
+}
+
+func (c *FileTokenCache) Save(key TokenCacheKey, token adal.Token) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	tokens, err := c.read()
+	if err != nil {
+		return err
+	}
+	for k, t := range tokens {
+		if t.IsExpired() {
+			delete(tokens, k)
+		}
+	}
+	tokens[key.String()] = token
+	return c.write(tokens)
+}
+
+// read decrypts the tokens of the file, a missing file is an empty cache
+func (c *FileTokenCache) read() (map[string]adal.Token, error) {
+	tokens := make(map[string]adal.Token)
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return tokens, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !bytes.HasPrefix(data, tokenCacheMagic) {
+		return tokens, nil
+	}
+	data = data[len(tokenCacheMagic):]
+	if len(data) < tokenCacheSaltSize {
+		return nil, fmt.Errorf("token cache %s is corrupted", c.path)
+	}
+	aead, err := c.cipher(data[:tokenCacheSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	data = data[tokenCacheSaltSize:]
+	nonceSize := aead.NonceSize()
+	if len(data) < nonceSize {
+		return nil, fmt.Errorf("token cache %s is corrupted", c.path)
+	}
+	plain, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt token cache %s: %s", c.path, err)
+	}
+	if err := json.Unmarshal(plain, &tokens); err != nil {
+		return nil, fmt.Errorf("failed to parse token cache %s: %s", c.path, err)
+	}
+	return tokens, nil
+}
+
+// write encrypts the tokens and replaces the file atomically
+func (c *FileTokenCache) write(tokens map[string]adal.Token) error {
+	plain, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	salt := c.salt
+	if salt == nil {
+		salt = make([]byte, tokenCacheSaltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return err
+		}
+	}
+	aead, err := c.cipher(salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	data := append(append([]byte(nil), tokenCacheMagic...), salt...)
+	data = aead.Seal(append(data, nonce...), nonce, plain, nil)
+
+	dir := filepath.Dir(c.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(dir, "token-cache")
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), c.path)
+}
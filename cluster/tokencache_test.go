@@ -0,0 +1,212 @@
+package cluster
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/banzaicloud/azure-aks-client/secrets"
+)
+
+func TestFileTokenCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aks-token-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "tokens")
+	cache, err := NewFileTokenCache(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := TokenCacheKey{TenantId: "tenantId", ClientId: "clientId", Resource: "resource"}
+	if token, err := cache.Load(key); err != nil || token != nil {
+		t.Fatalf("Expected empty cache, but got %v, %v", token, err)
+	}
+	if err := cache.Save(key, adal.Token{AccessToken: "secretToken"}); err != nil {
+		t.Fatalf("Error during save: %s", err)
+	}
+
+	reopened, _ := NewFileTokenCache(path, []byte("passphrase"))
+	token, err := reopened.Load(key)
+	if err != nil || token == nil || token.AccessToken != "secretToken" {
+		t.Errorf("Expected cached token, but got %v, %v", token, err)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	if string(data) == "" || strings.Contains(string(data), "secretToken") {
+		t.Error("Expected encrypted cache file")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected 0600 permissions, but got %v", info.Mode().Perm())
+	}
+
+	wrong, _ := NewFileTokenCache(path, []byte("wrong"))
+	if _, err := wrong.Load(key); err == nil {
+		t.Error("Expected error with wrong passphrase")
+	}
+
+	other, _ := NewFileTokenCache(filepath.Join(dir, "other"), []byte("passphrase"))
+	other.Save(key, adal.Token{AccessToken: "secretToken"})
+	otherData, _ := ioutil.ReadFile(filepath.Join(dir, "other"))
+	salt := len(tokenCacheMagic) + tokenCacheSaltSize
+	if string(data[:salt]) == string(otherData[:salt]) {
+		t.Error("Expected a random salt per cache file")
+	}
+}
+
+func TestFileTokenCacheEvictsExpired(t *testing.T) {
+	dir, err := ioutil.TempDir("", "aks-token-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache, _ := NewFileTokenCache(filepath.Join(dir, "tokens"), []byte("passphrase"))
+	expired := TokenCacheKey{TenantId: "tenantId", ClientId: "expired", Resource: "resource"}
+	valid := TokenCacheKey{TenantId: "tenantId", ClientId: "valid", Resource: "resource"}
+	expiresOn := func(d time.Duration) string { return fmt.Sprint(time.Now().Add(d).Unix()) }
+	if err := cache.Save(expired, adal.Token{AccessToken: "old", ExpiresOn: expiresOn(-time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Save(valid, adal.Token{AccessToken: "new", ExpiresOn: expiresOn(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	tokens, err := cache.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tokens[expired.String()]; ok || len(tokens) != 1 {
+		t.Errorf("Expected only the valid token to be kept, but got %v", tokens)
+	}
+}
+
+// newTokenServer stands in for the instance metadata endpoint, the tokens it issues expire in expiresIn
+func newTokenServer(expiresIn time.Duration, requests *int32, fail func(n int32) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(requests, 1)
+		if fail != nil && fail(n) {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		expiresOn := time.Now().Add(expiresIn).Unix()
+		fmt.Fprintf(w, `{"access_token":"token%d","expires_in":"%d","expires_on":"%d","resource":"https://management.azure.com/","token_type":"Bearer"}`,
+			n, int(expiresIn.Seconds()), expiresOn)
+	}))
+}
+
+func newManagedIdentityCredential(clientId, endpoint string) *AKSCredential {
+	return &AKSCredential{
+		AuthMethod:              AuthMethodManagedIdentity,
+		ClientId:                clientId,
+		SubscriptionId:          "subscriptionId",
+		ManagedIdentityEndpoint: endpoint,
+		ClusterIdentity:         &ClusterIdentity{ClientId: "clusterClientId", ClientSecret: "clusterSecret"},
+	}
+}
+
+func TestCachedTokenIsShared(t *testing.T) {
+	var requests int32
+	server := newTokenServer(time.Hour, &requests, nil)
+	defer server.Close()
+
+	credential := newManagedIdentityCredential("sharedIdentity", server.URL)
+	for i := 0; i < 3; i++ {
+		sdk, err := Authenticate(credential)
+		if err != nil {
+			t.Fatalf("Error during authenticate: %s", err)
+		}
+		if err := sdk.Token.EnsureFresh(); err != nil {
+			t.Fatalf("Error during getting token: %s", err)
+		}
+		if sdk.Token.OAuthToken() != "token1" {
+			t.Errorf("Expected the first token, but got %s", sdk.Token.OAuthToken())
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected one token request, but got %d", requests)
+	}
+}
+
+func TestCachedTokenBackgroundRefresh(t *testing.T) {
+	var requests int32
+	server := newTokenServer(DefaultRefreshWithin+time.Second, &requests, func(n int32) bool { return n > 1 })
+	defer server.Close()
+
+	sdk, err := Authenticate(newManagedIdentityCredential("backgroundIdentity", server.URL))
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+	errs := make(chan error, 1)
+	sdk.Token.OnRefreshError(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	})
+	sdk.Token.StartBackgroundRefresh()
+	defer sdk.Token.Stop()
+
+	if err := sdk.Token.EnsureFresh(); err != nil {
+		t.Fatalf("Error during getting token: %s", err)
+	}
+	select {
+	case err := <-errs:
+		if atomic.LoadInt32(&requests) != 2 {
+			t.Errorf("Expected background refresh request, but got %d requests: %s", requests, err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Expected reported refresh failure")
+	}
+}
+
+func TestCachedTokenIsKeyedByCredential(t *testing.T) {
+	newCredential := func(secret string) *AKSCredential {
+		return &AKSCredential{ClientId: "keyedClientId", ClientSecret: secret, SubscriptionId: "subscriptionId", TenantId: "tenantId"}
+	}
+	valid := newCredential("secret")
+	sdk, err := Authenticate(valid)
+	if err != nil {
+		t.Fatalf("Error during authenticate: %s", err)
+	}
+	key := tokenCacheKey(valid, sdk.Environment)
+	token := adal.Token{AccessToken: "validToken", ExpiresOn: fmt.Sprint(time.Now().Add(time.Hour).Unix())}
+	if err := DefaultTokenCache.Save(key, token); err != nil {
+		t.Fatal(err)
+	}
+
+	if sdk, _ := Authenticate(valid); sdk.Token.OAuthToken() != "validToken" {
+		t.Errorf("Expected the cached token of the same credential, but got %q", sdk.Token.OAuthToken())
+	}
+	if sdk, _ := Authenticate(newCredential("wrong")); sdk.Token.OAuthToken() != "" {
+		t.Errorf("Expected no token for another secret, but got %q", sdk.Token.OAuthToken())
+	}
+
+	fromSource := func(dir string) *AKSCredential {
+		credential := newCredential("")
+		credential.SecretSource = &secrets.FileSource{Dir: dir}
+		return credential
+	}
+	if tokenCacheKey(fromSource("/run/secrets"), sdk.Environment) != tokenCacheKey(fromSource("/run/secrets"), sdk.Environment) {
+		t.Error("Expected credentials of the same secret source to share the key")
+	}
+	if tokenCacheKey(fromSource("/run/secrets"), sdk.Environment) == tokenCacheKey(fromSource("/run/other"), sdk.Environment) {
+		t.Error("Expected credentials of different secret sources to have different keys")
+	}
+
+	first := newManagedIdentityCredential("", "http://localhost:1")
+	second := newManagedIdentityCredential("", "http://localhost:2")
+	if tokenCacheKey(first, sdk.Environment) == tokenCacheKey(second, sdk.Environment) {
+		t.Error("Expected managed identities of different endpoints to have different keys")
+	}
+}
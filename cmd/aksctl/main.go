@@ -0,0 +1,162 @@
+// Command aksctl is a small CLI around the AKS client for troubleshooting credentials, exporting and cloning
+// clusters.
+//
+//	aksctl [-credentials environment,authfile,profile] [-profile name] whoami
+//	aksctl [-credentials environment,authfile,profile] [-profile name] export -resource-group rg -name cluster
+//	aksctl [-credentials environment,authfile,profile] [-profile name] clone -resource-group rg -name cluster
+//		[-to-name name] [-to-resource-group rg] [-to-location location] [-to-subnet id] [-reuse-subnet] [-dry-run] [-wait]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/banzaicloud/azure-aks-client/client"
+	"github.com/banzaicloud/azure-aks-client/cluster"
+)
+
+func main() {
+	providers := flag.String("credentials", strings.Join([]string{cluster.ProviderEnvironment, cluster.ProviderAuthFile, cluster.ProviderProfile}, ","),
+		"comma separated credential providers tried in order")
+	profile := flag.String("profile", "", "profile of the profiles file, overrides "+cluster.AKSProfile)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] whoami|export|clone\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if len(*profile) != 0 {
+		os.Setenv(cluster.AKSProfile, *profile)
+	}
+
+	var err error
+	switch flag.Arg(0) {
+	case "whoami":
+		err = whoami(strings.Split(*providers, ","))
+	case "export":
+		err = export(strings.Split(*providers, ","), flag.Args()[1:])
+	case "clone":
+		err = clone(strings.Split(*providers, ","), flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// whoami prints the identity of the ARM access token obtained with the credentials of the providers
+func whoami(providers []string) error {
+	chain, err := cluster.NewChainProviderFromNames(providers, nil)
+	if err != nil {
+		return err
+	}
+	sdk, err := cluster.AuthenticateWithProvider(chain)
+	if err != nil {
+		return err
+	}
+	identity, err := sdk.WhoAmI()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Credential source: %s\n", sdk.CredentialSource)
+	fmt.Printf("Auth method:       %s\n", sdk.ServicePrincipal.AuthMethod)
+	fmt.Printf("Object ID:         %s\n", identity.ObjectId)
+	fmt.Printf("App ID:            %s\n", identity.AppId)
+	fmt.Printf("Tenant:            %s\n", identity.TenantId)
+	fmt.Printf("Audience:          %s\n", identity.Audience)
+	fmt.Printf("Roles:             %s\n", strings.Join(identity.Roles, ", "))
+	fmt.Printf("Expires:           %s\n", identity.ExpiresOn.Format(time.RFC3339))
+	for _, warning := range identity.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	return nil
+}
+
+// export prints the request recreating a cluster as JSON, and the settings it can't reproduce to stderr
+func export(providers []string, args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	resourceGroup := flags.String("resource-group", "", "resource group of the cluster")
+	name := flags.String("name", "", "name of the cluster")
+	flags.Parse(args)
+	if len(*resourceGroup) == 0 || len(*name) == 0 {
+		return fmt.Errorf("export needs -resource-group and -name")
+	}
+
+	aksClient, err := newClient(providers)
+	if err != nil {
+		return err
+	}
+	exported, err := client.ExportCluster(aksClient, *name, *resourceGroup)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.MarshalIndent(exported.Request, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	for _, field := range exported.Unreproducible {
+		fmt.Fprintf(os.Stderr, "not reproduced: %s %q: %s\n", field.Field, field.Value, field.Reason)
+	}
+	return nil
+}
+
+// clone creates a cluster equivalent to another one and prints the plan, only the plan with -dry-run
+func clone(providers []string, args []string) error {
+	flags := flag.NewFlagSet("clone", flag.ExitOnError)
+	resourceGroup := flags.String("resource-group", "", "resource group of the source cluster")
+	name := flags.String("name", "", "name of the source cluster")
+	var opts client.CloneOptions
+	flags.StringVar(&opts.Name, "to-name", "", "name of the clone, the name of the source if empty")
+	flags.StringVar(&opts.ResourceGroup, "to-resource-group", "", "resource group of the clone, the one of the source if empty")
+	flags.StringVar(&opts.Location, "to-location", "", "location of the clone, the one of the source if empty")
+	flags.StringVar(&opts.VnetSubnetId, "to-subnet", "", "subnet ID of the clone")
+	flags.BoolVar(&opts.ReuseSubnet, "reuse-subnet", false, "place the clone in the subnet of the source if -to-subnet is empty")
+	flags.BoolVar(&opts.DryRun, "dry-run", false, "print the plan without creating the clone")
+	flags.BoolVar(&opts.Wait, "wait", false, "wait until the clone is ready")
+	flags.Parse(args)
+	if len(*resourceGroup) == 0 || len(*name) == 0 {
+		return fmt.Errorf("clone needs -resource-group and -name")
+	}
+
+	aksClient, err := newClient(providers)
+	if err != nil {
+		return err
+	}
+	source := client.ClusterTarget{ResourceGroup: *resourceGroup, Name: *name}
+	plan, response, err := client.CloneCluster(context.Background(), aksClient, source, opts)
+	if plan != nil {
+		out, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	}
+	if err != nil {
+		return err
+	}
+	if response != nil {
+		fmt.Fprintf(os.Stderr, "clone %s/%s: %s (status %d)\n", plan.Request.ResourceGroup, plan.Request.Name,
+			response.Value.Properties.ProvisioningState, response.StatusCode)
+	}
+	return nil
+}
+
+// newClient returns an AKS client authenticated with the credentials of the providers
+func newClient(providers []string) (*client.AKSClient, error) {
+	chain, err := cluster.NewChainProviderFromNames(providers, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.GetAKSClientWithProvider(chain)
+}
@@ -0,0 +1,249 @@
+// Package hooks runs registered functions before and after cluster operations, e.g. to register DNS, notify a chat
+// or update a CMDB. Pre-hooks can change the request or veto the operation, post-hooks receive its result or error.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banzaicloud/azure-aks-client/cluster"
+)
+
+// Event is a cluster operation hooks run around
+type Event string
+
+const (
+	EventCreate    Event = "create"
+	EventUpdate    Event = "update"
+	EventScale     Event = "scale"
+	EventUpgrade   Event = "upgrade"
+	EventDelete    Event = "delete"
+	EventGetConfig Event = "getConfig"
+)
+
+// DefaultTimeout is the time a hook may run when neither the hook nor the registry sets a timeout
+const DefaultTimeout = 30 * time.Second
+
+// Operation is passed to the hooks of an operation
+type Operation struct {
+	Event         Event
+	ResourceGroup string
+	Name          string
+	// Request is the request of create, update, scale and upgrade. Pre-hooks may change it, the changed request is
+	// validated again before it's sent.
+	Request *cluster.CreateClusterRequest
+	// Result is the result of a successful operation for post-hooks: *azure.ResponseWithValue of create, update,
+	// scale and upgrade, *azure.Config of getConfig, nil of delete. It's shared by the hooks, which must not change it.
+	Result interface{}
+	// Err is the error the operation failed with for post-hooks, including a veto of a pre-hook
+	Err error
+}
+
+// copy returns a copy of the operation with its own request
+func (op *Operation) copy() *Operation {
+	copied := *op
+	copied.Request = op.Request.DeepCopy()
+	return &copied
+}
+
+// PreHook runs before an operation, an error vetoes it
+type PreHook func(ctx context.Context, op *Operation) error
+
+// PostHook runs after an operation, an error is reported but doesn't change the result of the operation
+type PostHook func(ctx context.Context, op *Operation) error
+
+// Hook describes a registered hook
+type Hook struct {
+	// Name identifies the hook in errors
+	Name string
+	// Priority orders the hooks of an event, lower runs first. Hooks of the same priority run in registration order.
+	Priority int
+	// Timeout of a run, the timeout of the registry if zero. The context of the hook is cancelled after it, and the
+	// run fails even if the hook ignores the context.
+	Timeout time.Duration
+	// Events the hook runs around, every event if empty
+	Events []Event
+}
+
+func (h *Hook) matches(event Event) bool {
+	if len(h.Events) == 0 {
+		return true
+	}
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// HookError is the failure of a hook
+type HookError struct {
+	Hook  string
+	Event Event
+	Err   error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s hook %s failed: %s", e.Event, e.Hook, e.Err)
+}
+
+// Errors are the failures of post-hooks
+type Errors []*HookError
+
+func (e Errors) Error() string {
+	var messages []string
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return strings.Join(messages, "; ")
+}
+
+type entry struct {
+	Hook
+	sequence int
+	run      func(ctx context.Context, op *Operation) error
+}
+
+// Registry holds the hooks of a client. A nil *Registry has no hooks.
+type Registry struct {
+	// Timeout of the hooks without one, DefaultTimeout if zero
+	Timeout time.Duration
+
+	lock     sync.RWMutex
+	pre      []*entry
+	post     []*entry
+	sequence int
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// AddPre registers a hook running before the operations of its events
+func (r *Registry) AddPre(hook Hook, fn PreHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.pre = r.add(r.pre, hook, fn)
+}
+
+// AddPost registers a hook running after the operations of its events
+func (r *Registry) AddPost(hook Hook, fn PostHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.post = r.add(r.post, hook, fn)
+}
+
+func (r *Registry) add(entries []*entry, hook Hook, fn func(ctx context.Context, op *Operation) error) []*entry {
+	r.sequence++
+	entries = append(entries, &entry{Hook: hook, sequence: r.sequence, run: fn})
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Priority != entries[j].Priority {
+			return entries[i].Priority < entries[j].Priority
+		}
+		return entries[i].sequence < entries[j].sequence
+	})
+	return entries
+}
+
+// Has returns true if hooks are registered for any of the events
+func (r *Registry) Has(events ...Event) bool {
+	if r == nil {
+		return false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, event := range events {
+		if len(matching(r.pre, event)) != 0 || len(matching(r.post, event)) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RunPre runs the pre-hooks of the operation's event in order. The first failure stops the run and is returned as a
+// *HookError, vetoing the operation.
+func (r *Registry) RunPre(op *Operation) error {
+	for _, e := range r.entries(true, op.Event) {
+		if err := r.run(e, op); err != nil {
+			return &HookError{Hook: e.Name, Event: op.Event, Err: err}
+		}
+	}
+	return nil
+}
+
+// RunPost runs every post-hook of the operation's event in order, and returns their failures as Errors
+func (r *Registry) RunPost(op *Operation) error {
+	var errs Errors
+	for _, e := range r.entries(false, op.Event) {
+		if err := r.run(e, op); err != nil {
+			errs = append(errs, &HookError{Hook: e.Name, Event: op.Event, Err: err})
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+// entries returns the pre- or post-hooks of the event
+func (r *Registry) entries(pre bool, event Event) []*entry {
+	if r == nil {
+		return nil
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if pre {
+		return matching(r.pre, event)
+	}
+	return matching(r.post, event)
+}
+
+func matching(entries []*entry, event Event) []*entry {
+	var result []*entry
+	for _, e := range entries {
+		if e.matches(event) {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// run runs the hook with its timeout, a panic of the hook is returned as an error. The hook gets a copy of op, which
+// replaces op if the hook returns in time. A hook that times out may keep running, but only on its own copy, so it
+// can't race with the later hooks and the operation.
+func (r *Registry) run(e *entry, op *Operation) error {
+	timeout := e.Timeout
+	if timeout <= 0 {
+		timeout = r.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	work := op.copy()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				done <- fmt.Errorf("panic: %v", p)
+			}
+		}()
+		done <- e.run(ctx, work)
+	}()
+
+	select {
+	case err := <-done:
+		*op = *work
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
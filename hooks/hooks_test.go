@@ -0,0 +1,125 @@
+package hooks
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banzaicloud/azure-aks-client/cluster"
+)
+
+func TestOrdering(t *testing.T) {
+	var order []string
+	hook := func(name string) PreHook {
+		return func(ctx context.Context, op *Operation) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	r := NewRegistry()
+	r.AddPre(Hook{Name: "cmdb", Priority: 10}, hook("cmdb"))
+	r.AddPre(Hook{Name: "dns", Priority: 0}, hook("dns"))
+	r.AddPre(Hook{Name: "chat", Priority: 10}, hook("chat"))
+	r.AddPre(Hook{Name: "deleteOnly", Events: []Event{EventDelete}}, hook("deleteOnly"))
+
+	if err := r.RunPre(&Operation{Event: EventCreate}); err != nil {
+		t.Fatal(err)
+	}
+	if exp := []string{"dns", "cmdb", "chat"}; !reflect.DeepEqual(exp, order) {
+		t.Errorf("Expected order %v, but got %v", exp, order)
+	}
+	if !r.Has(EventDelete) || !r.Has(EventGetConfig) {
+		t.Error("Expected hooks of every event")
+	}
+	var nilRegistry *Registry
+	if nilRegistry.Has(EventCreate) || nilRegistry.RunPre(&Operation{}) != nil || nilRegistry.RunPost(&Operation{}) != nil {
+		t.Error("Expected nil registry without hooks")
+	}
+}
+
+func TestVeto(t *testing.T) {
+	r := NewRegistry()
+	r.AddPre(Hook{Name: "veto"}, func(ctx context.Context, op *Operation) error {
+		return errors.New("not today")
+	})
+	ran := false
+	r.AddPre(Hook{Name: "later", Priority: 1}, func(ctx context.Context, op *Operation) error {
+		ran = true
+		return nil
+	})
+
+	err := r.RunPre(&Operation{Event: EventDelete})
+	if hookErr, ok := err.(*HookError); !ok || hookErr.Hook != "veto" || hookErr.Event != EventDelete {
+		t.Errorf("Expected veto, but got %v", err)
+	}
+	if ran {
+		t.Error("Expected hooks after the veto not to run")
+	}
+}
+
+func TestPostErrors(t *testing.T) {
+	r := NewRegistry()
+	r.Timeout = 10 * time.Millisecond
+	r.AddPost(Hook{Name: "slow"}, func(ctx context.Context, op *Operation) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	r.AddPost(Hook{Name: "panicking"}, func(ctx context.Context, op *Operation) error {
+		panic("boom")
+	})
+	ran := false
+	r.AddPost(Hook{Name: "ok", Timeout: time.Second}, func(ctx context.Context, op *Operation) error {
+		ran = true
+		return nil
+	})
+
+	err := r.RunPost(&Operation{Event: EventCreate})
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Expected 2 errors, but got %v", err)
+	}
+	if !strings.Contains(errs[0].Error(), "timed out") || !strings.Contains(errs[1].Error(), "panic: boom") {
+		t.Errorf("Unexpected errors: %s", errs)
+	}
+	if !ran {
+		t.Error("Expected every post-hook to run")
+	}
+}
+
+func TestTimedOutHookIsIsolated(t *testing.T) {
+	r := NewRegistry()
+	release := make(chan struct{})
+	finished := make(chan struct{})
+	r.AddPre(Hook{Name: "late", Timeout: 10 * time.Millisecond}, func(ctx context.Context, op *Operation) error {
+		<-release
+		op.Request.Tags["late"] = "true"
+		op.Request.AgentName = "late"
+		close(finished)
+		return nil
+	})
+	r.AddPost(Hook{Name: "tagging"}, func(ctx context.Context, op *Operation) error {
+		op.Request.Tags["post"] = "true"
+		return nil
+	})
+
+	request := &cluster.CreateClusterRequest{AgentName: "agent", Tags: map[string]string{"env": "prod"}}
+	op := &Operation{Event: EventCreate, Request: request}
+	if err := r.RunPre(op); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected timeout, but got %v", err)
+	}
+	close(release)
+	<-finished
+	if op.Request != request || op.Request.AgentName != "agent" || len(op.Request.Tags) != 1 {
+		t.Errorf("Expected the operation unchanged by the timed out hook, but got %+v", op.Request)
+	}
+
+	if err := r.RunPost(op); err != nil {
+		t.Fatal(err)
+	}
+	if op.Request.Tags["post"] != "true" || request.Tags["post"] != "" {
+		t.Errorf("Expected the changes of the hook in the operation only, but got %v and %v", op.Request.Tags, request.Tags)
+	}
+}
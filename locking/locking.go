@@ -0,0 +1,311 @@
+// Package locking serializes the mutating operations on a cluster, within a process or, with the file backend,
+// between the processes of a host.
+package locking
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode tells what happens when a cluster is locked by another operation
+type Mode int
+
+const (
+	// ModeWait waits until the lock is released, or the Timeout of the Manager passes
+	ModeWait Mode = iota
+	// ModeFailFast returns a *ConflictError right away
+	ModeFailFast
+)
+
+// DefaultRetryInterval is the time a waiting Manager waits between two attempts
+const DefaultRetryInterval = 100 * time.Millisecond
+
+// ConflictError is returned when the lock of a cluster is held by another operation
+type ConflictError struct {
+	Key       string
+	Operation string
+	// Holder describes the operation holding the lock, if the backend knows it
+	Holder string
+}
+
+func (e *ConflictError) Error() string {
+	if len(e.Holder) == 0 {
+		return fmt.Sprintf("%s of %s conflicts with another operation in progress", e.Operation, e.Key)
+	}
+	return fmt.Sprintf("%s of %s conflicts with %s in progress", e.Operation, e.Key, e.Holder)
+}
+
+// IsConflict returns true if err is a *ConflictError
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// Key returns the lock key of a cluster. ARM names are case-insensitive, so the key is too.
+func Key(subscriptionId, resourceGroup, name string) string {
+	return strings.ToLower(subscriptionId + "/" + resourceGroup + "/" + name)
+}
+
+// Backend holds the locks
+type Backend interface {
+	// TryLock acquires the lock of key for holder without waiting. If it's held, it returns false and the holder
+	// of the lock, if known.
+	TryLock(key, holder string) (bool, string, error)
+	// Unlock releases the lock of key
+	Unlock(key string) error
+}
+
+// Manager locks clusters for the mutating operations
+type Manager struct {
+	Backend Backend
+	Mode    Mode
+	// Timeout is the longest time ModeWait waits for a lock, forever if zero
+	Timeout time.Duration
+	// RetryInterval is the time ModeWait waits between two attempts, DefaultRetryInterval if zero
+	RetryInterval time.Duration
+}
+
+// NewManager creates a manager with backend, a new MemoryBackend if nil
+func NewManager(backend Backend, mode Mode) *Manager {
+	if backend == nil {
+		backend = NewMemoryBackend()
+	}
+	return &Manager{Backend: backend, Mode: mode}
+}
+
+// Lock acquires the lock of key for operation, and returns the function releasing it. It fails with a
+// *ConflictError if the lock is held and the mode is ModeFailFast or the timeout passes, or with the error of ctx if
+// it's done first.
+func (m *Manager) Lock(ctx context.Context, key, operation string) (func() error, error) {
+	holder := fmt.Sprintf("%s (pid %d, since %s)", operation, os.Getpid(), time.Now().UTC().Format(time.RFC3339))
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	interval := m.RetryInterval
+	if interval <= 0 {
+		interval = DefaultRetryInterval
+	}
+
+	for {
+		ok, current, err := m.Backend.TryLock(key, holder)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return func() error {
+				return m.Backend.Unlock(key)
+			}, nil
+		}
+		conflict := &ConflictError{Key: key, Operation: operation, Holder: current}
+		if m.Mode == ModeFailFast {
+			return nil, conflict
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded && m.Timeout > 0 {
+				return nil, conflict
+			}
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// MemoryBackend holds the locks of a process
+type MemoryBackend struct {
+	lock    sync.Mutex
+	holders map[string]string
+}
+
+// NewMemoryBackend creates an empty backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{holders: make(map[string]string)}
+}
+
+func (b *MemoryBackend) TryLock(key, holder string) (bool, string, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if current, ok := b.holders[key]; ok {
+		return false, current, nil
+	}
+	b.holders[key] = holder
+	return true, "", nil
+}
+
+func (b *MemoryBackend) Unlock(key string) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.holders, key)
+	return nil
+}
+
+// FileBackend holds the locks as files of a directory, so the processes of a host using the same directory
+// exclude each other. A lock file is created exclusively and holds a random token of its holder and the description
+// of the holder. Lock files are only removed while holding the token they were read with, so a released or stale lock
+// can't remove the lock another backend took over.
+type FileBackend struct {
+	Dir string
+	// StaleAfter removes the lock files older than it, left behind by crashed processes. Lock files are kept until
+	// released if zero.
+	StaleAfter time.Duration
+
+	lock   sync.Mutex
+	tokens map[string]string
+}
+
+// TakenOverError is returned by FileBackend.Unlock when the lock was removed as stale and is held by another holder
+type TakenOverError struct {
+	Key    string
+	Holder string
+}
+
+func (e *TakenOverError) Error() string {
+	return fmt.Sprintf("lock of %s was taken over by %s", e.Key, e.Holder)
+}
+
+// NewFileBackend creates a backend in dir, creating the directory if needed
+func NewFileBackend(dir string) (*FileBackend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &FileBackend{Dir: dir}, nil
+}
+
+// path returns the lock file of key, the key is hashed as cluster names may hold characters invalid in file names
+func (b *FileBackend) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(b.Dir, hex.EncodeToString(sum[:16])+".lock")
+}
+
+func (b *FileBackend) TryLock(key, holder string) (bool, string, error) {
+	path := b.path(key)
+	token, err := newToken()
+	if err != nil {
+		return false, "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		current, currentHolder, err := readLockFile(path)
+		if os.IsNotExist(err) {
+			return b.TryLock(key, holder)
+		} else if err != nil {
+			return false, "", err
+		}
+		if b.stale(path) {
+			if removed, err := removeLockFile(path, current); err != nil {
+				return false, "", err
+			} else if removed {
+				return b.TryLock(key, holder)
+			}
+		}
+		return false, currentHolder, nil
+	} else if err != nil {
+		return false, "", err
+	}
+	_, err = f.WriteString(token + "\n" + key + ": " + holder + "\n")
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return false, "", err
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.tokens == nil {
+		b.tokens = make(map[string]string)
+	}
+	b.tokens[key] = token
+	return true, "", nil
+}
+
+// stale returns true if the lock file is older than StaleAfter
+func (b *FileBackend) stale(path string) bool {
+	if b.StaleAfter <= 0 {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && time.Since(info.ModTime()) >= b.StaleAfter
+}
+
+// Unlock removes the lock file of key if it still holds the token of this backend. It fails with a *TakenOverError
+// if the lock was removed as stale and taken by another holder, whose lock is left in place.
+func (b *FileBackend) Unlock(key string) error {
+	b.lock.Lock()
+	token, ok := b.tokens[key]
+	delete(b.tokens, key)
+	b.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	path := b.path(key)
+	removed, err := removeLockFile(path, token)
+	if err != nil || removed {
+		return err
+	}
+	if _, holder, err := readLockFile(path); err == nil {
+		return &TakenOverError{Key: key, Holder: holder}
+	}
+	return nil
+}
+
+// readLockFile returns the token and the holder description of a lock file
+func readLockFile(path string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", "", err
+	}
+	lines := strings.SplitN(string(data), "\n", 2)
+	if len(lines) < 2 {
+		return strings.TrimSpace(lines[0]), "", nil
+	}
+	return lines[0], strings.TrimSpace(lines[1]), nil
+}
+
+// removeLockFile removes the lock file if it holds token. The file is renamed aside before its token is checked, so
+// a lock created in its place meanwhile isn't removed; a file of another holder is linked back. It returns false
+// if the file is missing or held by another token.
+func removeLockFile(path, token string) (bool, error) {
+	suffix, err := newToken()
+	if err != nil {
+		return false, err
+	}
+	aside := path + "." + suffix + ".removing"
+	if err := os.Rename(path, aside); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	current, _, err := readLockFile(aside)
+	if err == nil && current == token {
+		return true, os.Remove(aside)
+	}
+	err = os.Link(aside, path)
+	os.Remove(aside)
+	if os.IsExist(err) {
+		// the lock was taken again while it was aside, its holder keeps it
+		return false, nil
+	}
+	return false, err
+}
+
+func newToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
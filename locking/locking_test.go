@@ -0,0 +1,150 @@
+package locking
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	key := Key("sub", "RG", "Cluster")
+	if key != "sub/rg/cluster" {
+		t.Errorf("Expected case-insensitive key, but got %s", key)
+	}
+
+	failFast := NewManager(nil, ModeFailFast)
+	unlock, err := failFast.Lock(context.Background(), key, "DeleteCluster")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = failFast.Lock(context.Background(), key, "CreateUpdateCluster")
+	if conflict, ok := err.(*ConflictError); !ok || !strings.HasPrefix(conflict.Holder, "DeleteCluster") {
+		t.Fatalf("Expected conflict with the delete, but got %v", err)
+	}
+	if unlock, err := failFast.Lock(context.Background(), Key("sub", "rg", "other"), "DeleteCluster"); err != nil {
+		t.Errorf("Expected other clusters unlocked, but got %v", err)
+	} else {
+		unlock()
+	}
+	unlock()
+	if unlock, err := failFast.Lock(context.Background(), key, "CreateUpdateCluster"); err != nil {
+		t.Errorf("Expected released lock, but got %v", err)
+	} else {
+		unlock()
+	}
+}
+
+func TestWait(t *testing.T) {
+	m := NewManager(nil, ModeWait)
+	m.RetryInterval = time.Millisecond
+
+	var lock sync.Mutex
+	inside, maxInside := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := m.Lock(context.Background(), "key", "op")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			inside++
+			if inside > maxInside {
+				maxInside = inside
+			}
+			lock.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			lock.Lock()
+			inside--
+			lock.Unlock()
+			unlock()
+		}()
+	}
+	wg.Wait()
+	if maxInside != 1 {
+		t.Errorf("Expected serialized operations, but %d ran at once", maxInside)
+	}
+
+	unlock, _ := m.Lock(context.Background(), "key", "op")
+	defer unlock()
+	m.Timeout = 10 * time.Millisecond
+	if _, err := m.Lock(context.Background(), "key", "op"); !IsConflict(err) {
+		t.Errorf("Expected conflict after timeout, but got %v", err)
+	}
+	m.Timeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.Lock(ctx, "key", "op"); err != context.Canceled {
+		t.Errorf("Expected cancellation, but got %v", err)
+	}
+}
+
+func TestFileBackend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "locks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// two backends on the same directory stand for two processes
+	first, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := NewFileBackend(dir)
+
+	if ok, _, err := first.TryLock("key", "first"); !ok || err != nil {
+		t.Fatalf("Expected lock, but got %v", err)
+	}
+	if ok, holder, _ := second.TryLock("key", "second"); ok || holder != "key: first" {
+		t.Errorf("Expected lock held by first, but got %v %s", ok, holder)
+	}
+
+	if err := second.Unlock("key"); err != nil {
+		t.Error(err)
+	}
+	if ok, _, _ := second.TryLock("key", "second"); ok {
+		t.Error("Expected the unlock of second to leave the lock of first in place")
+	}
+
+	second.StaleAfter = time.Hour
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(first.path("key"), old, old)
+	if ok, _, _ := second.TryLock("key", "second"); !ok {
+		t.Fatal("Expected stale lock removed")
+	}
+	if err, ok := first.Unlock("key").(*TakenOverError); !ok || err.Holder != "key: second" {
+		t.Errorf("Expected the lock taken over by second, but got %v", err)
+	}
+	if ok, holder, _ := first.TryLock("key", "first"); ok || holder != "key: second" {
+		t.Errorf("Expected the unlock of first to leave the lock of second in place, but got %v %s", ok, holder)
+	}
+	if err := second.Unlock("key"); err != nil {
+		t.Error(err)
+	}
+	if ok, _, _ := first.TryLock("key", "first"); !ok {
+		t.Error("Expected released lock")
+	}
+	first.Unlock("key")
+
+	// removing a stale lock checks it still holds the token it was read with
+	if ok, _, _ := first.TryLock("key", "first"); !ok {
+		t.Fatal("Expected lock")
+	}
+	if removed, err := removeLockFile(first.path("key"), "staleToken"); removed || err != nil {
+		t.Errorf("Expected the lock of another token kept, but got %v, %v", removed, err)
+	}
+	if ok, _, _ := second.TryLock("key", "second"); ok {
+		t.Error("Expected the lock of first in place")
+	}
+	if err := first.Unlock("key"); err != nil {
+		t.Error(err)
+	}
+}
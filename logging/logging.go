@@ -0,0 +1,133 @@
+// Package logging is the structured logger interface of the client, with logrus, no-op and redacting
+// implementations.
+package logging
+
+import (
+	"github.com/banzaicloud/azure-aks-client/redact"
+	"github.com/sirupsen/logrus"
+)
+
+// Names of the fields the client adds to its log messages
+const (
+	FieldSubscription  = "subscription"
+	FieldResourceGroup = "resourceGroup"
+	FieldCluster       = "cluster"
+	FieldOperation     = "operation"
+	FieldRequestID     = "requestId"
+	FieldError         = "error"
+	// FieldRequest is the CreateClusterRequest of an operation
+	FieldRequest = "request"
+	// FieldModel is the managed cluster model sent to Azure
+	FieldModel = "model"
+)
+
+// Fields are the key/value context of a log message
+type Fields map[string]interface{}
+
+// Logger is a leveled logger of messages with fields
+type Logger interface {
+	Debug(msg string, fields ...Fields)
+	Info(msg string, fields ...Fields)
+	Warn(msg string, fields ...Fields)
+	Error(msg string, fields ...Fields)
+	// With returns a Logger adding the fields to every message
+	With(fields Fields) Logger
+}
+
+// merge returns the union of the fields, later ones override earlier ones
+func merge(fields []Fields) Fields {
+	switch len(fields) {
+	case 0:
+		return nil
+	case 1:
+		return fields[0]
+	}
+	merged := Fields{}
+	for _, f := range fields {
+		for k, v := range f {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// logrusLogger adapts a logrus logger
+type logrusLogger struct {
+	logger logrus.FieldLogger
+}
+
+// NewLogrusLogger creates a Logger writing to a *logrus.Logger or *logrus.Entry
+func NewLogrusLogger(logger logrus.FieldLogger) Logger {
+	return &logrusLogger{logger: logger}
+}
+
+func (l *logrusLogger) entry(fields []Fields) logrus.FieldLogger {
+	if f := merge(fields); len(f) != 0 {
+		return l.logger.WithFields(logrus.Fields(f))
+	}
+	return l.logger
+}
+
+func (l *logrusLogger) Debug(msg string, fields ...Fields) { l.entry(fields).Debug(msg) }
+func (l *logrusLogger) Info(msg string, fields ...Fields)  { l.entry(fields).Info(msg) }
+func (l *logrusLogger) Warn(msg string, fields ...Fields)  { l.entry(fields).Warn(msg) }
+func (l *logrusLogger) Error(msg string, fields ...Fields) { l.entry(fields).Error(msg) }
+
+func (l *logrusLogger) With(fields Fields) Logger {
+	return &logrusLogger{logger: l.logger.WithFields(logrus.Fields(fields))}
+}
+
+// nopLogger discards every message
+type nopLogger struct{}
+
+// NewNopLogger creates a Logger discarding every message
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+func (nopLogger) Debug(msg string, fields ...Fields) {}
+func (nopLogger) Info(msg string, fields ...Fields)  {}
+func (nopLogger) Warn(msg string, fields ...Fields)  {}
+func (nopLogger) Error(msg string, fields ...Fields) {}
+func (l nopLogger) With(fields Fields) Logger        { return l }
+
+// redactingLogger masks the messages and fields before passing them to its logger
+type redactingLogger struct {
+	logger   Logger
+	redactor *redact.Redactor
+}
+
+// NewRedactingLogger creates a Logger masking the messages and field values of logger with redactor
+func NewRedactingLogger(logger Logger, redactor *redact.Redactor) Logger {
+	return &redactingLogger{logger: logger, redactor: redactor}
+}
+
+func (l *redactingLogger) fields(fields []Fields) []Fields {
+	if f := merge(fields); len(f) != 0 {
+		return []Fields{l.redactor.Value(f).(Fields)}
+	}
+	return nil
+}
+
+func (l *redactingLogger) Debug(msg string, fields ...Fields) {
+	l.logger.Debug(l.redactor.String(msg), l.fields(fields)...)
+}
+
+func (l *redactingLogger) Info(msg string, fields ...Fields) {
+	l.logger.Info(l.redactor.String(msg), l.fields(fields)...)
+}
+
+func (l *redactingLogger) Warn(msg string, fields ...Fields) {
+	l.logger.Warn(l.redactor.String(msg), l.fields(fields)...)
+}
+
+func (l *redactingLogger) Error(msg string, fields ...Fields) {
+	l.logger.Error(l.redactor.String(msg), l.fields(fields)...)
+}
+
+func (l *redactingLogger) With(fields Fields) Logger {
+	if len(fields) == 0 {
+		return l
+	}
+	return &redactingLogger{logger: l.logger.With(l.fields([]Fields{fields})[0]), redactor: l.redactor}
+}
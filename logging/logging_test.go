@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/banzaicloud/azure-aks-client/redact"
+	"github.com/sirupsen/logrus"
+)
+
+func newTestLogger() (*logrus.Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	logger := logrus.New()
+	logger.Out = &out
+	logger.Level = logrus.DebugLevel
+	logger.Formatter = new(logrus.JSONFormatter)
+	return logger, &out
+}
+
+func TestLogrusLogger(t *testing.T) {
+	logrusLogger, out := newTestLogger()
+	logger := NewLogrusLogger(logrusLogger).With(Fields{FieldCluster: "cluster", FieldOperation: "GetCluster"})
+
+	logger.Warn("Status code: 200", Fields{FieldRequestID: "requestId", FieldOperation: "PollingCluster"})
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected JSON entry, but got %s", out.String())
+	}
+	exp := map[string]interface{}{
+		"msg":          "Status code: 200",
+		"level":        "warning",
+		FieldCluster:   "cluster",
+		FieldOperation: "PollingCluster",
+		FieldRequestID: "requestId",
+	}
+	for k, v := range exp {
+		if entry[k] != v {
+			t.Errorf("Expected %s %v, but got %v", k, v, entry[k])
+		}
+	}
+}
+
+func TestNopLogger(t *testing.T) {
+	logger := NewNopLogger().With(Fields{FieldCluster: "cluster"})
+	logger.Debug("debug")
+	logger.Error("error", Fields{FieldRequestID: "requestId"})
+}
+
+func TestRedactingLogger(t *testing.T) {
+	logrusLogger, out := newTestLogger()
+	logger := NewRedactingLogger(NewLogrusLogger(logrusLogger), redact.New()).With(Fields{"clientSecret": "topSecret"})
+
+	logger.Info("token: abc.def", Fields{"password": "topSecret", FieldCluster: "cluster"})
+
+	if strings.Contains(out.String(), "topSecret") || strings.Contains(out.String(), "abc.def") {
+		t.Errorf("Expected secrets masked, but got %s", out.String())
+	}
+	if !strings.Contains(out.String(), `"cluster":"cluster"`) {
+		t.Errorf("Expected unmasked cluster field, but got %s", out.String())
+	}
+}
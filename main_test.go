@@ -1,17 +1,27 @@
 package main_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2018-04-01/compute"
 	"github.com/Azure/azure-sdk-for-go/services/containerservice/mgmt/2017-09-30/containerservice"
 	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2016-06-01/subscriptions"
 	"github.com/Azure/go-autorest/autorest"
+	"github.com/banzaicloud/azure-aks-client/audit"
 	"github.com/banzaicloud/azure-aks-client/client"
 	"github.com/banzaicloud/azure-aks-client/cluster"
+	"github.com/banzaicloud/azure-aks-client/hooks"
+	"github.com/banzaicloud/azure-aks-client/locking"
+	"github.com/banzaicloud/azure-aks-client/logging"
 	"github.com/banzaicloud/azure-aks-client/utils"
 	"github.com/banzaicloud/banzai-types/components/azure"
 	"github.com/banzaicloud/banzai-types/constants"
 	"net/http"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -55,10 +65,18 @@ var mc = containerservice.ManagedCluster{
 	Location: utils.S(location1),
 }
 
+// TestCluster returns mc from Get. If missing is set, Get doesn't find the cluster until it's created, then returns
+// the created one in state, the state of mc if empty.
 type TestCluster struct {
+	created *containerservice.ManagedCluster
+	puts    int
+	missing bool
+	state   string
 }
 
 func (t *TestCluster) CreateOrUpdate(request *cluster.CreateClusterRequest, managedCluster *containerservice.ManagedCluster) (*containerservice.ManagedCluster, error) {
+	t.created = managedCluster
+	t.puts++
 	return &mc, nil
 }
 
@@ -69,7 +87,20 @@ func (t *TestCluster) Delete(resourceGroup, name string) (*http.Response, error)
 }
 
 func (t *TestCluster) Get(resourceGroup, name string) (containerservice.ManagedCluster, error) {
-	return mc, nil
+	if !t.missing {
+		return mc, nil
+	}
+	if t.created == nil {
+		return containerservice.ManagedCluster{}, &utils.AKSError{StatusCode: http.StatusNotFound}
+	}
+	created := *t.created
+	properties := *created.ManagedClusterProperties
+	properties.ProvisioningState, properties.Fqdn = mc.ProvisioningState, mc.Fqdn
+	if len(t.state) != 0 {
+		properties.ProvisioningState = utils.S(t.state)
+	}
+	created.ManagedClusterProperties, created.ID, created.Response = &properties, mc.ID, mc.Response
+	return created, nil
 }
 
 func (t *TestCluster) List() ([]containerservice.ManagedCluster, error) {
@@ -140,18 +171,7 @@ func (t *TestCluster) ListVersions(locations, resourceType string) (result conta
 func (t *TestCluster) GetClientId() string     { return "testClientId" }
 func (t *TestCluster) GetClientSecret() string { return "testClientSecret" }
 
-func (t *TestCluster) LogDebug(args ...interface{})                 {}
-func (t *TestCluster) LogInfo(args ...interface{})                  {}
-func (t *TestCluster) LogWarn(args ...interface{})                  {}
-func (t *TestCluster) LogError(args ...interface{})                 {}
-func (t *TestCluster) LogFatal(args ...interface{})                 {}
-func (t *TestCluster) LogPanic(args ...interface{})                 {}
-func (t *TestCluster) LogDebugf(format string, args ...interface{}) {}
-func (t *TestCluster) LogInfof(format string, args ...interface{})  {}
-func (t *TestCluster) LogWarnf(format string, args ...interface{})  {}
-func (t *TestCluster) LogErrorf(format string, args ...interface{}) {}
-func (t *TestCluster) LogFatalf(format string, args ...interface{}) {}
-func (t *TestCluster) LogPanicf(format string, args ...interface{}) {}
+func (t *TestCluster) Logger() logging.Logger { return logging.NewNopLogger() }
 
 var manager = &TestCluster{}
 
@@ -164,6 +184,9 @@ func TestCreateOrUpdate(t *testing.T) {
 		error
 	}{
 		{name: "full create", request: createRequest, expResponse: createResponse, error: nil},
+		{name: "key vault secret", request: createRequestKeyVault, expResponse: createResponse, error: nil},
+		{name: "management identity", request: createRequestManagementIdentity, expResponse: nil, error: client.ErrManagementIdentity},
+		{name: "allowed management identity", request: createRequestAllowManagementIdentity, expResponse: createResponse, error: nil},
 		{name: "empty name", request: createRequestEmptyName, expResponse: nil, error: constants.ErrorAzureClusterNameEmpty},
 		{name: "too long name", request: createRequestTooLongName, expResponse: nil, error: constants.ErrorAzureClusterNameTooLong},
 		{name: "regexp name", request: createRequestWrongName, expResponse: nil, error: constants.ErrorAzureClusterNameRegexp},
@@ -171,6 +194,7 @@ func TestCreateOrUpdate(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
+			manager := &TestCluster{missing: true}
 			if managedCluster, err := client.CreateUpdateCluster(manager, tc.request); err != nil {
 				if tc.error == nil {
 					t.Errorf("Error during create cluster: %s", err.Error())
@@ -188,6 +212,106 @@ func TestCreateOrUpdate(t *testing.T) {
 
 }
 
+func TestCreateClusterIdentity(t *testing.T) {
+	cases := []struct {
+		name        string
+		request     *cluster.CreateClusterRequest
+		expClientId string
+		expSecret   string
+		expVault    string
+	}{
+		{name: "secret", request: createRequest, expClientId: "clusterClientId", expSecret: "clusterSecret"},
+		{name: "key vault", request: createRequestKeyVault, expClientId: "clusterClientId", expVault: "vaultId"},
+		{name: "management", request: createRequestAllowManagementIdentity, expClientId: "testClientId", expSecret: "testClientSecret"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			manager := &TestCluster{missing: true}
+			if _, err := client.CreateUpdateCluster(manager, tc.request); err != nil {
+				t.Fatalf("Error during create cluster: %s", err)
+			}
+			profile := manager.created.ServicePrincipalProfile
+			if *profile.ClientID != tc.expClientId {
+				t.Errorf("Expected client id %s, but got %s", tc.expClientId, *profile.ClientID)
+			}
+			if tc.expSecret != "" && (profile.Secret == nil || *profile.Secret != tc.expSecret || profile.KeyVaultSecretRef != nil) {
+				t.Errorf("Expected secret %s, but got %v", tc.expSecret, profile)
+			}
+			if tc.expVault != "" && (profile.Secret != nil || profile.KeyVaultSecretRef == nil || *profile.KeyVaultSecretRef.VaultID != tc.expVault) {
+				t.Errorf("Expected key vault secret of %s, but got %v", tc.expVault, profile)
+			}
+		})
+	}
+}
+
+func TestIdempotentCreate(t *testing.T) {
+	manager := &TestCluster{missing: true}
+	if _, err := client.CreateUpdateCluster(manager, createRequest); err != nil {
+		t.Fatalf("Error during create cluster: %s", err)
+	}
+
+	retried := *createRequest
+	retried.Location = "East US"
+	response, err := client.CreateUpdateCluster(manager, &retried)
+	if err != nil || response.StatusCode != client.StatusUnchanged || response.Value.Name != name {
+		t.Fatalf("Expected unchanged cluster, but got %v: %v", response, err)
+	}
+
+	scaled := *createRequest
+	scaled.AgentCount = 3
+	_, err = client.CreateUpdateCluster(manager, &scaled)
+	conflict, ok := err.(*client.SpecConflictError)
+	if !ok || len(conflict.Differences) != 1 || conflict.Differences[0] != (cluster.SpecDifference{Field: "AgentCount", Existing: "1", Requested: "3"}) {
+		t.Fatalf("Expected conflicting agent count, but got %v", err)
+	}
+
+	tagged := *createRequest
+	tagged.Tags = map[string]string{"env": "prod"}
+	moved := *createRequest
+	moved.VnetSubnetId = "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/aks"
+	for _, edited := range []*cluster.CreateClusterRequest{&tagged, &moved} {
+		if _, err := client.CreateUpdateCluster(manager, edited); err == nil {
+			t.Errorf("Expected conflicting tags or subnet, but got no error")
+		} else if _, ok := err.(*client.SpecConflictError); !ok {
+			t.Errorf("Expected conflicting tags or subnet, but got %v", err)
+		}
+	}
+	if manager.puts != 1 {
+		t.Errorf("Expected a single PUT, but got %d", manager.puts)
+	}
+
+	otherIdentity := *createRequest
+	otherIdentity.ClusterIdentity = &cluster.ClusterIdentity{ClientId: "otherClientId", ClientSecret: "otherSecret"}
+	_, err = client.CreateUpdateCluster(manager, &otherIdentity)
+	if conflict, ok := err.(*client.SpecConflictError); !ok || conflict.Differences[0].Field != "ServicePrincipalClientId" {
+		t.Errorf("Expected conflicting service principal, but got %v", err)
+	}
+
+	manager.state = "Creating"
+	if response, err := client.CreateUpdateCluster(manager, createRequest); err != nil || response.StatusCode != client.StatusUnchanged ||
+		response.Value.Properties.ProvisioningState != "Creating" {
+		t.Errorf("Expected the cluster in progress unchanged, but got %v: %v", response, err)
+	}
+	manager.state = "Deleting"
+	if _, err := client.CreateUpdateCluster(manager, createRequest); err != client.ErrClusterDeleting {
+		t.Errorf("Expected a cluster being deleted to fail, but got %v", err)
+	}
+	manager.state = "Failed"
+	if _, err := client.CreateUpdateCluster(manager, createRequest); err != nil || manager.puts != 2 {
+		t.Fatalf("Expected the failed cluster sent again, but got %d PUTs: %v", manager.puts, err)
+	}
+	manager.state = ""
+
+	scaled.AllowUpdate = true
+	if response, err := client.CreateUpdateCluster(manager, &scaled); err != nil || response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected updated cluster, but got %v: %v", response, err)
+	}
+	if manager.puts != 3 || *(*manager.created.AgentPoolProfiles)[0].Count != 3 {
+		t.Errorf("Expected the update sent, but got %d PUTs", manager.puts)
+	}
+}
+
 func TestDeleteCluster(t *testing.T) {
 	if err := client.DeleteCluster(manager, name, rg); err != nil {
 		t.Errorf("Error during deleting cluster: %s", err.Error())
@@ -195,6 +319,336 @@ func TestDeleteCluster(t *testing.T) {
 	}
 }
 
+// AuditedCluster records its operations in an audit trail
+type AuditedCluster struct {
+	TestCluster
+	trail *audit.Trail
+}
+
+func (a *AuditedCluster) AuditTrail() *audit.Trail  { return a.trail }
+func (a *AuditedCluster) AuditActor() audit.Actor   { return audit.Actor{ClientId: "testClientId"} }
+func (a *AuditedCluster) GetSubscriptionId() string { return "testSubscriptionId" }
+
+func TestAuditTrail(t *testing.T) {
+	var out bytes.Buffer
+	trail, err := audit.NewTrail(audit.NewWriterSink(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditedManager := &AuditedCluster{TestCluster: TestCluster{missing: true}, trail: trail}
+
+	if _, err := client.CreateUpdateCluster(auditedManager, createRequest); err != nil {
+		t.Fatalf("Error during create cluster: %s", err)
+	}
+	if _, err := client.CreateUpdateCluster(auditedManager, createRequestEmptyName); err == nil {
+		t.Fatal("Expected validation error")
+	}
+	if err := client.DeleteCluster(auditedManager, name, rg); err != nil {
+		t.Fatalf("Error during deleting cluster: %s", err)
+	}
+
+	if strings.Contains(out.String(), "clusterSecret") {
+		t.Errorf("Expected secrets masked, but got %s", out.String())
+	}
+	if count, err := audit.Verify(bytes.NewReader(out.Bytes())); err != nil || count != 2 {
+		t.Fatalf("Expected 2 chained records, but got %d: %v", count, err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var created, deleted audit.Record
+	json.Unmarshal([]byte(lines[0]), &created)
+	json.Unmarshal([]byte(lines[1]), &deleted)
+	if created.Operation != audit.OperationCreateUpdate || created.Actor.ClientId != "testClientId" ||
+		created.Target != (audit.Target{SubscriptionId: "testSubscriptionId", ResourceGroup: rg, Name: name}) ||
+		!created.Result.Success || created.Result.StatusCode != http.StatusOK {
+		t.Errorf("Unexpected create record: %+v", created)
+	}
+	if deleted.Operation != audit.OperationDelete || deleted.Result.StatusCode != http.StatusAccepted || deleted.Request != nil {
+		t.Errorf("Unexpected delete record: %+v", deleted)
+	}
+}
+
+// HookedCluster runs lifecycle hooks around its operations
+type HookedCluster struct {
+	TestCluster
+	registry *hooks.Registry
+	existing *containerservice.ManagedCluster
+}
+
+func (h *HookedCluster) Hooks() *hooks.Registry { return h.registry }
+
+func (h *HookedCluster) Get(resourceGroup, name string) (containerservice.ManagedCluster, error) {
+	if h.existing == nil {
+		return containerservice.ManagedCluster{}, &utils.AKSError{StatusCode: http.StatusNotFound}
+	}
+	return *h.existing, nil
+}
+
+func TestHooks(t *testing.T) {
+	var events []hooks.Event
+	var results []interface{}
+	registry := hooks.NewRegistry()
+	registry.AddPre(hooks.Hook{Name: "rename"}, func(ctx context.Context, op *hooks.Operation) error {
+		events = append(events, op.Event)
+		if op.Request != nil {
+			op.Request.AgentName = "hookedAgent"
+			op.Request.Tags["hooked"] = "true"
+			op.Request.ClusterIdentity.ClientId = "hookedClientId"
+		}
+		return nil
+	})
+	registry.AddPre(hooks.Hook{Name: "protect", Events: []hooks.Event{hooks.EventDelete}}, func(ctx context.Context, op *hooks.Operation) error {
+		return errors.New("protected")
+	})
+	registry.AddPost(hooks.Hook{Name: "notify"}, func(ctx context.Context, op *hooks.Operation) error {
+		results = append(results, op.Result, op.Err)
+		return nil
+	})
+
+	hooked := &HookedCluster{registry: registry}
+	existing := func(version, vmSize string, count int32) *containerservice.ManagedCluster {
+		return &containerservice.ManagedCluster{
+			ID:       utils.S(id),
+			Location: utils.S(location1),
+			ManagedClusterProperties: &containerservice.ManagedClusterProperties{
+				KubernetesVersion: &version,
+				AgentPoolProfiles: &[]containerservice.AgentPoolProfile{
+					{Name: utils.S(agentName), Count: &count, VMSize: containerservice.VMSizeTypes(vmSize)},
+				},
+			},
+		}
+	}
+	request := *createRequest
+	request.AllowUpdate = true
+	request.Tags = map[string]string{"env": "prod"}
+	identity := *createRequest.ClusterIdentity
+	request.ClusterIdentity = &identity
+
+	cases := []struct {
+		name     string
+		existing *containerservice.ManagedCluster
+		expEvent hooks.Event
+	}{
+		{name: "create", existing: nil, expEvent: hooks.EventCreate},
+		{name: "upgrade", existing: existing("1.7.7", vmSize1, agentCount), expEvent: hooks.EventUpgrade},
+		{name: "scale", existing: existing(k8sVersion, vmSize1, 3), expEvent: hooks.EventScale},
+		{name: "update", existing: existing(k8sVersion, vmSize2, agentCount), expEvent: hooks.EventUpdate},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			events, results = nil, nil
+			hooked.existing = tc.existing
+			response, err := client.CreateUpdateCluster(hooked, &request)
+			if err != nil {
+				t.Fatalf("Error during create cluster: %s", err)
+			}
+			if len(events) != 1 || events[0] != tc.expEvent {
+				t.Errorf("Expected event %s, but got %v", tc.expEvent, events)
+			}
+			if name := *(*hooked.created.AgentPoolProfiles)[0].Name; name != "hookedAgent" || request.AgentName != agentName {
+				t.Errorf("Expected only the sent request changed, but got agent %s", name)
+			}
+			if len(request.Tags) != 1 || request.ClusterIdentity.ClientId != createRequest.ClusterIdentity.ClientId ||
+				*hooked.created.Tags["hooked"] != "true" {
+				t.Errorf("Expected the tags and identity of the caller's request unchanged, but got %v, %v", request.Tags, request.ClusterIdentity)
+			}
+			if len(results) != 2 || results[0] != response || results[1] != nil {
+				t.Errorf("Expected the response in post-hook, but got %v", results)
+			}
+		})
+	}
+
+	results = nil
+	err := client.DeleteCluster(hooked, name, rg)
+	if hookErr, ok := err.(*hooks.HookError); !ok || hookErr.Hook != "protect" {
+		t.Fatalf("Expected veto, but got %v", err)
+	}
+	if len(results) != 2 || results[0] != nil || results[1] != err {
+		t.Errorf("Expected the veto in post-hook, but got %v", results)
+	}
+}
+
+func TestPostHookKeepsError(t *testing.T) {
+	registry := hooks.NewRegistry()
+	registry.AddPre(hooks.Hook{Name: "protect"}, func(ctx context.Context, op *hooks.Operation) error {
+		return errors.New("protected")
+	})
+	registry.AddPost(hooks.Hook{Name: "clear"}, func(ctx context.Context, op *hooks.Operation) error {
+		op.Result, op.Err = nil, nil
+		return nil
+	})
+	hooked := &HookedCluster{registry: registry}
+
+	if err := client.DeleteCluster(hooked, name, rg); err == nil {
+		t.Error("Expected the veto of the delete, but got no error")
+	}
+	if response, err := client.CreateUpdateCluster(hooked, createRequest); err == nil || response != nil {
+		t.Errorf("Expected the veto of the create, but got %v, %v", response, err)
+	}
+}
+
+// HookedStore runs hooks and returns the cluster it created
+type HookedStore struct {
+	TestCluster
+	registry *hooks.Registry
+}
+
+func (h *HookedStore) Hooks() *hooks.Registry { return h.registry }
+
+func TestHookedIdempotentCreate(t *testing.T) {
+	owner := func(ctx context.Context, op *hooks.Operation) error {
+		tags := map[string]string{"owner": "team"}
+		for k, v := range op.Request.Tags {
+			tags[k] = v
+		}
+		op.Request.Tags = tags
+		return nil
+	}
+
+	hooked := &HookedStore{TestCluster: TestCluster{missing: true}, registry: hooks.NewRegistry()}
+	hooked.registry.AddPre(hooks.Hook{Name: "owner"}, owner)
+	for i := 0; i < 2; i++ {
+		response, err := client.CreateUpdateCluster(hooked, createRequest)
+		if err != nil {
+			t.Fatalf("Error during create cluster: %s", err)
+		}
+		if i == 1 && response.StatusCode != client.StatusUnchanged {
+			t.Errorf("Expected the repeated create unchanged, but got status %d", response.StatusCode)
+		}
+	}
+	if hooked.puts != 1 || *hooked.created.Tags["owner"] != "team" {
+		t.Errorf("Expected a single PUT of the hooked request, but got %d", hooked.puts)
+	}
+
+	// a cluster matching the caller's request doesn't match once a hook changes it
+	plain := &HookedStore{TestCluster: TestCluster{missing: true}, registry: hooks.NewRegistry()}
+	if _, err := client.CreateUpdateCluster(plain, createRequest); err != nil {
+		t.Fatalf("Error during create cluster: %s", err)
+	}
+	plain.registry.AddPre(hooks.Hook{Name: "owner"}, owner)
+	_, err := client.CreateUpdateCluster(plain, createRequest)
+	if conflict, ok := err.(*client.SpecConflictError); !ok || conflict.Differences[0].Field != "Tags" {
+		t.Errorf("Expected conflicting tags, but got %v", err)
+	}
+}
+
+// FailingCluster fails to delete the clusters named broken
+type FailingCluster struct {
+	TestCluster
+	lock    sync.Mutex
+	deleted []string
+}
+
+func (f *FailingCluster) Delete(resourceGroup, name string) (*http.Response, error) {
+	if name == "broken" {
+		return nil, errors.New("no access")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.deleted = append(f.deleted, name)
+	return f.TestCluster.Delete(resourceGroup, name)
+}
+
+func TestBulk(t *testing.T) {
+	targets := []client.ClusterTarget{{ResourceGroup: rg, Name: "first"}, {ResourceGroup: rg, Name: "broken"}, {ResourceGroup: rg, Name: "last"}}
+
+	results, err := client.BulkGet(context.Background(), manager, targets, client.BulkOptions{})
+	if err != nil || len(results) != 3 || results[2].Target != targets[2] || results[2].Response.Value.Name != name {
+		t.Fatalf("Unexpected bulk get results %v: %v", results, err)
+	}
+
+	results, err = client.BulkCreate(context.Background(), &TestCluster{missing: true}, []*cluster.CreateClusterRequest{createRequest, createRequestEmptyName}, client.BulkOptions{Wait: true})
+	if bulkErr, ok := err.(*client.BulkError); !ok || bulkErr.Total != 2 || len(bulkErr.Failed) != 1 || bulkErr.Failed[0].Err != constants.ErrorAzureClusterNameEmpty {
+		t.Fatalf("Expected one failed create, but got %v", err)
+	}
+	if results[0].Err != nil || results[0].Response.StatusCode != http.StatusCreated {
+		t.Errorf("Expected polled cluster, but got %v", results[0])
+	}
+
+	cases := []struct {
+		name       string
+		opts       client.BulkOptions
+		cancelled  bool
+		expDeleted int
+		expFailed  int
+	}{
+		{name: "independent", opts: client.BulkOptions{Parallelism: 1}, expDeleted: 2, expFailed: 1},
+		{name: "fail fast", opts: client.BulkOptions{Parallelism: 1, FailFast: true}, expDeleted: 1, expFailed: 2},
+		{name: "cancelled", opts: client.BulkOptions{}, cancelled: true, expDeleted: 0, expFailed: 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			if tc.cancelled {
+				cancel()
+			}
+			defer cancel()
+
+			failing := &FailingCluster{}
+			results, err := client.BulkDelete(ctx, failing, targets, tc.opts)
+			if len(results) != 3 || err == nil {
+				t.Fatalf("Expected 3 results and an error, but got %v: %v", results, err)
+			}
+			if len(failing.deleted) != tc.expDeleted {
+				t.Errorf("Expected %d deleted clusters, but got %v", tc.expDeleted, failing.deleted)
+			}
+			failed := 0
+			for _, r := range results {
+				if r.Err != nil {
+					failed++
+				}
+			}
+			if failed != tc.expFailed {
+				t.Errorf("Expected %d failures, but got %v", tc.expFailed, results)
+			}
+		})
+	}
+}
+
+// LockedCluster serializes its operations, its deletes block until released
+type LockedCluster struct {
+	TestCluster
+	locks    *locking.Manager
+	deleting chan struct{}
+	release  chan struct{}
+}
+
+func (l *LockedCluster) Locks() *locking.Manager   { return l.locks }
+func (l *LockedCluster) GetSubscriptionId() string { return "testSubscriptionId" }
+
+func (l *LockedCluster) Delete(resourceGroup, name string) (*http.Response, error) {
+	l.deleting <- struct{}{}
+	<-l.release
+	return l.TestCluster.Delete(resourceGroup, name)
+}
+
+func TestLocking(t *testing.T) {
+	locked := &LockedCluster{
+		TestCluster: TestCluster{missing: true},
+		locks:       locking.NewManager(nil, locking.ModeFailFast),
+		deleting:    make(chan struct{}),
+		release:     make(chan struct{}),
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- client.DeleteCluster(locked, name, rg)
+	}()
+	<-locked.deleting
+
+	_, err := client.CreateUpdateCluster(locked, createRequest)
+	if conflict, ok := err.(*locking.ConflictError); !ok || !strings.HasPrefix(conflict.Holder, "DeleteCluster") {
+		t.Errorf("Expected conflict with the delete, but got %v", err)
+	}
+
+	close(locked.release)
+	if err := <-done; err != nil {
+		t.Fatalf("Error during deleting cluster: %s", err)
+	}
+	if _, err := client.CreateUpdateCluster(locked, createRequest); err != nil {
+		t.Errorf("Expected the lock released, but got %v", err)
+	}
+}
+
 func TestGetClusterConfig(t *testing.T) {
 
 	exp := &azure.Config{
@@ -258,6 +712,93 @@ func TestGetCluster(t *testing.T) {
 	}
 }
 
+func TestGetClusterInfo(t *testing.T) {
+	info, err := client.GetClusterInfo(manager, name, rg)
+	if err != nil {
+		t.Fatalf("Error during getting cluster info: %s", err)
+	}
+	if info.Name != name || info.Fqdn != fqdn || !reflect.DeepEqual(info.ToValue(), createResponse.Value) {
+		t.Errorf("Unexpected cluster info: %+v", info)
+	}
+
+	infos, err := client.ListClusterInfos(manager)
+	if err != nil || len(infos) != 1 || infos[0].Name != name {
+		t.Errorf("Unexpected cluster infos %v: %v", infos, err)
+	}
+}
+
+// CloneSource returns the source cluster from Get, the other clusters are missing until created
+type CloneSource struct {
+	TestCluster
+	source containerservice.ManagedCluster
+}
+
+func (c *CloneSource) Get(resourceGroup, name string) (containerservice.ManagedCluster, error) {
+	if resourceGroup == rg && name == "source" {
+		return c.source, nil
+	}
+	return c.TestCluster.Get(resourceGroup, name)
+}
+
+func TestCloneCluster(t *testing.T) {
+	subnet := "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Network/virtualNetworks/vnet/subnets/aks"
+	newSource := func(version string) *CloneSource {
+		request := *createRequest
+		request.Name, request.KubernetesVersion, request.DNSPrefix, request.VnetSubnetId = "source", version, "source-dns", subnet
+		request.SSHPublicKeys, request.Tags = []string{"ssh-rsa AAAA"}, map[string]string{"env": "prod"}
+		source := *cluster.NewManagedCluster(&request, request.ClusterIdentity)
+		source.ID, source.Response = utils.S("/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ContainerService/managedClusters/source"), mc.Response
+		return &CloneSource{TestCluster: TestCluster{missing: true}, source: source}
+	}
+	source := client.ClusterTarget{ResourceGroup: rg, Name: "source"}
+	identity := &cluster.ClusterIdentity{ClientId: "cloneClientId", ClientSecret: "cloneSecret"}
+
+	cloning := newSource(k8sVersion)
+	plan, response, err := client.CloneCluster(context.Background(), cloning, source, client.CloneOptions{ResourceGroup: "dr", DryRun: true})
+	if cloneErr, ok := err.(*client.CloneError); !ok || len(cloneErr.Problems) != 2 ||
+		!strings.Contains(cloneErr.Problems[0], "share subnet") || !strings.Contains(cloneErr.Problems[1], client.ErrManagementIdentity.Error()) {
+		t.Errorf("Expected subnet and identity problems, but got %v", err)
+	}
+	request := plan.Request
+	if request.Name != "source" || request.ResourceGroup != "dr" || request.DNSPrefix != "source-dns" || request.VnetSubnetId != subnet ||
+		request.ClusterIdentity != nil || request.Tags["env"] != "prod" || request.Tags[client.CloneTag] != *cloning.source.ID {
+		t.Errorf("Unexpected clone plan %+v", request)
+	}
+
+	opts := client.CloneOptions{ResourceGroup: "dr", ReuseSubnet: true, ClusterIdentity: identity, DryRun: true}
+	plan, response, err = client.CloneCluster(context.Background(), cloning, source, opts)
+	if err != nil || response != nil || cloning.puts != 0 {
+		t.Fatalf("Expected a dry run, but got %v, %v, %d puts", response, err, cloning.puts)
+	}
+	if plan.Request.VnetSubnetId != subnet {
+		t.Errorf("Expected the subnet of the source reused, but got %s", plan.Request.VnetSubnetId)
+	}
+
+	opts = client.CloneOptions{Name: "clone", Location: location2, ReuseSubnet: true, ClusterIdentity: identity}
+	_, _, err = client.CloneCluster(context.Background(), newSource("1.7.0"), source, opts)
+	if cloneErr, ok := err.(*client.CloneError); !ok || len(cloneErr.Problems) != 2 ||
+		!strings.Contains(cloneErr.Problems[0], "subnet") || !strings.Contains(cloneErr.Problems[1], "1.7.0") {
+		t.Errorf("Expected subnet and version problems, but got %v", err)
+	}
+
+	_, _, err = client.CloneCluster(context.Background(), cloning, source, client.CloneOptions{})
+	if _, ok := err.(*client.CloneError); !ok {
+		t.Errorf("Expected a clone onto the source to fail, but got %v", err)
+	}
+
+	drSubnet := strings.Replace(subnet, "/vnet/", "/vnet-dr/", 1)
+	opts = client.CloneOptions{Name: "clone", Location: location2, VnetSubnetId: drSubnet, ClusterIdentity: identity, Wait: true}
+	plan, response, err = client.CloneCluster(context.Background(), cloning, source, opts)
+	if err != nil || response.StatusCode != http.StatusCreated || cloning.puts != 1 {
+		t.Fatalf("Expected the clone created, but got %v, %v, %d puts", response, err, cloning.puts)
+	}
+	created := cloning.created
+	if *created.Name != "clone" || *created.Location != location2 || *created.DNSPrefix != "clone-dns" ||
+		*(*created.AgentPoolProfiles)[0].VnetSubnetID != drSubnet || *created.ServicePrincipalProfile.ClientID != identity.ClientId {
+		t.Errorf("Unexpected clone %+v", created.ManagedClusterProperties)
+	}
+}
+
 func TestPollingCluster(t *testing.T) {
 	exp := pollingResponse
 
@@ -328,6 +869,42 @@ var (
 		AgentCount:        agentCount,
 		AgentName:         agentName,
 		KubernetesVersion: k8sVersion,
+		ClusterIdentity:   &cluster.ClusterIdentity{ClientId: "clusterClientId", ClientSecret: "clusterSecret"},
+	}
+
+	createRequestKeyVault = &cluster.CreateClusterRequest{
+		Name:              name,
+		Location:          location1,
+		VMSize:            vmSize1,
+		ResourceGroup:     rg,
+		AgentCount:        agentCount,
+		AgentName:         agentName,
+		KubernetesVersion: k8sVersion,
+		ClusterIdentity: &cluster.ClusterIdentity{
+			ClientId:       "clusterClientId",
+			KeyVaultSecret: &cluster.KeyVaultSecretRef{VaultId: "vaultId", SecretName: "secretName"},
+		},
+	}
+
+	createRequestManagementIdentity = &cluster.CreateClusterRequest{
+		Name:              name,
+		Location:          location1,
+		VMSize:            vmSize1,
+		ResourceGroup:     rg,
+		AgentCount:        agentCount,
+		AgentName:         agentName,
+		KubernetesVersion: k8sVersion,
+	}
+
+	createRequestAllowManagementIdentity = &cluster.CreateClusterRequest{
+		Name:                    name,
+		Location:                location1,
+		VMSize:                  vmSize1,
+		ResourceGroup:           rg,
+		AgentCount:              agentCount,
+		AgentName:               agentName,
+		KubernetesVersion:       k8sVersion,
+		AllowManagementIdentity: true,
 	}
 
 	createRequestEmptyName = &cluster.CreateClusterRequest{
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// ARM reports the remaining subscription quota in these response headers
+const (
+	RemainingReadsHeader  = "x-ms-ratelimit-remaining-subscription-reads"
+	RemainingWritesHeader = "x-ms-ratelimit-remaining-subscription-writes"
+)
+
+// Default hourly ARM quota of a subscription
+const (
+	DefaultReadsPerHour  = 12000
+	DefaultWritesPerHour = 1200
+)
+
+// Budget is the remaining read and write budget. Server values are the last ones reported by ARM, or -1 if
+// no response has reported them yet.
+type Budget struct {
+	Reads        int `json:"reads"`
+	Writes       int `json:"writes"`
+	ServerReads  int `json:"serverReads"`
+	ServerWrites int `json:"serverWrites"`
+}
+
+// bucket is a token bucket refilled continuously up to its capacity
+type bucket struct {
+	capacity float64
+	tokens   float64
+	perSec   float64
+	last     time.Time
+	server   int
+}
+
+func newBucket(perHour int, now time.Time) *bucket {
+	return &bucket{
+		capacity: float64(perHour),
+		tokens:   float64(perHour),
+		perSec:   float64(perHour) / time.Hour.Seconds(),
+		last:     now,
+		server:   -1,
+	}
+}
+
+// refill adds the tokens accumulated since the last refill
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.perSec)
+		b.last = now
+	}
+}
+
+// take removes a token, or returns how long to wait for one
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	wait := time.Duration((1 - b.tokens) / b.perSec * float64(time.Second))
+	if wait < time.Millisecond {
+		wait = time.Millisecond
+	}
+	return false, wait
+}
+
+// adjust lowers the local budget to the remaining quota reported by ARM
+func (b *bucket) adjust(remaining int, now time.Time) {
+	b.refill(now)
+	b.server = remaining
+	b.tokens = math.Min(b.tokens, float64(remaining))
+}
+
+// Limiter is a token bucket rate limiter with separate budgets for ARM reads and writes. As the ARM quota is per
+// subscription, the requests of a subscription are charged to the budgets of its own limiter, see Subscription.
+type Limiter struct {
+	mu            sync.Mutex
+	reads         *bucket
+	writes        *bucket
+	now           func() time.Time
+	readsPerHour  int
+	writesPerHour int
+	subscriptions map[string]*Limiter
+}
+
+// NewLimiter creates a limiter with the passed hourly read and write budgets
+func NewLimiter(readsPerHour, writesPerHour int) *Limiter {
+	now := time.Now()
+	return &Limiter{
+		reads:         newBucket(readsPerHour, now),
+		writes:        newBucket(writesPerHour, now),
+		now:           time.Now,
+		readsPerHour:  readsPerHour,
+		writesPerHour: writesPerHour,
+		subscriptions: make(map[string]*Limiter),
+	}
+}
+
+// NewDefaultLimiter creates a limiter with the default ARM quota of a subscription
+func NewDefaultLimiter() *Limiter {
+	return NewLimiter(DefaultReadsPerHour, DefaultWritesPerHour)
+}
+
+// Wait blocks until the budget allows the request with the passed HTTP method or the context is done
+func (l *Limiter) Wait(ctx context.Context, method string) error {
+	for {
+		l.mu.Lock()
+		ok, wait := l.bucketFor(method).take(l.now())
+		l.mu.Unlock()
+
+		if ok {
+			return nil
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Update adjusts the budgets to the remaining quota headers of the response
+func (l *Limiter) Update(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	if remaining, err := strconv.Atoi(resp.Header.Get(RemainingReadsHeader)); err == nil {
+		l.reads.adjust(remaining, now)
+	}
+	if remaining, err := strconv.Atoi(resp.Header.Get(RemainingWritesHeader)); err == nil {
+		l.writes.adjust(remaining, now)
+	}
+}
+
+// Remaining returns the current budget, e.g. for monitoring
+func (l *Limiter) Remaining() Budget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.reads.refill(now)
+	l.writes.refill(now)
+	return Budget{
+		Reads:        int(l.reads.tokens),
+		Writes:       int(l.writes.tokens),
+		ServerReads:  l.reads.server,
+		ServerWrites: l.writes.server,
+	}
+}
+
+// Subscription returns the limiter of a subscription, with the same hourly budgets as l. The requests the
+// SendDecorator of l sends to the subscription are charged to it, e.g. for monitoring its Remaining budget.
+func (l *Limiter) Subscription(subscriptionId string) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	subscriptionId = strings.ToLower(subscriptionId)
+	subscription, ok := l.subscriptions[subscriptionId]
+	if !ok {
+		subscription = NewLimiter(l.readsPerHour, l.writesPerHour)
+		subscription.now = l.now
+		l.subscriptions[subscriptionId] = subscription
+	}
+	return subscription
+}
+
+// SendDecorator returns the decorator to plug into the autorest Sender of the SDK clients. Requests of a subscription
+// are charged to its limiter, the others, like listing the subscriptions, to l.
+func (l *Limiter) SendDecorator() autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (*http.Response, error) {
+			limiter := l.limiterFor(r)
+			if err := limiter.Wait(r.Context(), r.Method); err != nil {
+				return nil, err
+			}
+			resp, err := s.Do(r)
+			limiter.Update(resp)
+			return resp, err
+		})
+	}
+}
+
+// limiterFor returns the limiter of the subscription the request is sent to
+func (l *Limiter) limiterFor(r *http.Request) *Limiter {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) > 1 && strings.EqualFold(segments[0], "subscriptions") && segments[1] != "" {
+		return l.Subscription(segments[1])
+	}
+	return l
+}
+
+// bucketFor returns the budget the request with the passed HTTP method is charged to
+func (l *Limiter) bucketFor(method string) *bucket {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		return l.reads
+	default:
+		return l.writes
+	}
+}
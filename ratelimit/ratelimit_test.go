@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(3600, 2)
+	now := l.reads.last
+	l.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(ctx, http.MethodPut); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := l.Wait(ctx, http.MethodGet); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Budget{Reads: 3599, Writes: 0, ServerReads: -1, ServerWrites: -1}
+	if b := l.Remaining(); b != exp {
+		t.Errorf("Expected budget %v, but got %v", exp, b)
+	}
+
+	canceled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(canceled, http.MethodDelete); err == nil {
+		t.Error("Expected error when write budget is exhausted")
+	}
+
+	now = now.Add(time.Second)
+	if b := l.Remaining(); b.Reads != 3600 {
+		t.Errorf("Expected read budget to be refilled, but got %d", b.Reads)
+	}
+}
+
+func TestSendDecorator(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(RemainingReadsHeader, "10")
+		w.Header().Set(RemainingWritesHeader, "5")
+	}))
+	defer server.Close()
+
+	l := NewDefaultLimiter()
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	if _, err := autorest.SendWithSender(&http.Client{}, req, l.SendDecorator()); err != nil {
+		t.Fatal(err)
+	}
+
+	b := l.Remaining()
+	if b.ServerReads != 10 || b.ServerWrites != 5 || b.Reads != 10 || b.Writes != 5 {
+		t.Errorf("Expected budget to follow the response headers, but got %v", b)
+	}
+}
+
+func TestSubscriptionBudgets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/subscriptions/throttled/") {
+			w.Header().Set(RemainingReadsHeader, "0")
+		}
+	}))
+	defer server.Close()
+
+	l := NewLimiter(3600, 3600)
+	send := func(path string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		_, err := autorest.SendWithSender(&http.Client{}, req.WithContext(ctx), l.SendDecorator())
+		return err
+	}
+	if err := send("/subscriptions/throttled/providers"); err != nil {
+		t.Fatal(err)
+	}
+	if err := send("/subscriptions/other/providers"); err != nil {
+		t.Errorf("Expected the budget of another subscription to be unaffected, but got %s", err)
+	}
+	if err := send("/subscriptions/THROTTLED/providers"); err == nil {
+		t.Error("Expected the throttled subscription to wait for its budget")
+	}
+
+	if b := l.Subscription("throttled").Remaining(); b.ServerReads != 0 || b.Reads != 0 {
+		t.Errorf("Expected the throttled budget to follow the response header, but got %v", b)
+	}
+	if b := l.Subscription("other").Remaining(); b.ServerReads != -1 || b.Reads != 3599 {
+		t.Errorf("Expected one read charged to the other subscription, but got %v", b)
+	}
+	if b := l.Remaining(); b.Reads != 3600 {
+		t.Errorf("Expected no read charged outside the subscriptions, but got %v", b)
+	}
+}
@@ -0,0 +1,317 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Mode tells whether the recorder captures the real conversation or serves it back from the cassette
+type Mode int
+
+const (
+	// ModeRecord sends every request to Azure and captures the request/response pair
+	ModeRecord Mode = iota
+	// ModeReplay serves responses from the cassette without calling Azure
+	ModeReplay
+)
+
+// Placeholders used in place of scrubbed values
+const (
+	ScrubbedGUID  = "00000000-0000-0000-0000-000000000000"
+	ScrubbedValue = "[REDACTED]"
+)
+
+// Interaction is one recorded request/response pair
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is the scrubbed part of the request that is used for matching
+type Request struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Body   string `json:"body,omitempty"`
+}
+
+// Response is the scrubbed response served back in replay mode
+type Response struct {
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// Cassette is the content of a recording file
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+type scrubber struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// defaultScrubbers remove subscription and tenant IDs, tokens and secrets from the recorded conversation
+var defaultScrubbers = []scrubber{
+	{
+		pattern:     regexp.MustCompile(`(?i)(/subscriptions/)[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`),
+		replacement: "${1}" + ScrubbedGUID,
+	},
+	{
+		pattern:     regexp.MustCompile(`(?i)("(?:subscriptionId|tenantId)"\s*:\s*")[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}"`),
+		replacement: "${1}" + ScrubbedGUID + `"`,
+	},
+	{
+		pattern:     regexp.MustCompile(`(?i)("(?:secret|password|clientSecret|kubeConfig|access_?token|refresh_?token|id_?token)"\s*:\s*)"[^"]*"`),
+		replacement: `${1}"` + ScrubbedValue + `"`,
+	},
+}
+
+// ignoredHeaders are never written into the cassette
+var ignoredHeaders = []string{"Set-Cookie", "Authorization"}
+
+// Recorder captures the HTTP conversation of the Azure SDK clients into a cassette file, or serves a previously
+// recorded cassette back
+type Recorder struct {
+	mode      Mode
+	path      string
+	scrubbers []scrubber
+
+	mu       sync.Mutex
+	cassette *Cassette
+	used     []bool
+}
+
+// New creates a recorder for the cassette at path. In replay mode the cassette is loaded immediately.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{
+		mode:      mode,
+		path:      path,
+		scrubbers: append([]scrubber{}, defaultScrubbers...),
+		cassette:  &Cassette{},
+	}
+
+	if mode == ModeReplay {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, r.cassette); err != nil {
+			return nil, fmt.Errorf("invalid cassette %s: %s", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	}
+
+	return r, nil
+}
+
+// Mode returns the mode of the recorder
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// ScrubValue replaces every occurrence of the passed literal value (e.g. a known client secret) with a placeholder
+func (r *Recorder) ScrubValue(value string) {
+	if len(value) == 0 {
+		return
+	}
+	r.ScrubPattern(regexp.MustCompile(regexp.QuoteMeta(value)), ScrubbedValue)
+}
+
+// ScrubPattern replaces every match of the passed pattern with the replacement, see regexp.ReplaceAllString
+func (r *Recorder) ScrubPattern(pattern *regexp.Regexp, replacement string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.scrubbers = append(r.scrubbers, scrubber{pattern: pattern, replacement: replacement})
+}
+
+// SendDecorator returns the decorator to plug into the autorest Sender of the SDK clients. In replay mode the
+// decorated Sender is never called.
+func (r *Recorder) SendDecorator() autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(req *http.Request) (*http.Response, error) {
+			if r.mode == ModeReplay {
+				return r.replay(req)
+			}
+			return r.record(s, req)
+		})
+	}
+}
+
+// Stop writes the cassette to its file in record mode
+func (r *Recorder) Stop() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	b, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(r.path, b, 0644)
+}
+
+// Unused returns the recorded interactions that have not been served in replay mode
+func (r *Recorder) Unused() []*Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []*Interaction
+	for i, used := range r.used {
+		if !used {
+			unused = append(unused, r.cassette.Interactions[i])
+		}
+	}
+	return unused
+}
+
+// record sends the request and captures the scrubbed request/response pair
+func (r *Recorder) record(s autorest.Sender, req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.Do(req)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	respBody, err := readResponseBody(resp)
+	if err != nil {
+		return resp, err
+	}
+
+	header := http.Header{}
+	for k, v := range resp.Header {
+		header[k] = append([]string{}, v...)
+	}
+	for _, h := range ignoredHeaders {
+		header.Del(h)
+	}
+	for k, values := range header {
+		for i := range values {
+			values[i] = r.scrub(values[i])
+		}
+		header[k] = values
+	}
+
+	interaction := &Interaction{
+		Request: r.scrubRequest(req, reqBody),
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Header:     header,
+			Body:       r.scrub(string(respBody)),
+		},
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+
+	return resp, nil
+}
+
+// replay serves the first unused interaction that matches the request
+func (r *Recorder) replay(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	actual := r.scrubRequest(req, reqBody)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || interaction.Request.Method != actual.Method || interaction.Request.URL != actual.URL {
+			continue
+		}
+		r.used[i] = true
+
+		header := http.Header{}
+		for k, v := range interaction.Response.Header {
+			header[k] = append([]string{}, v...)
+		}
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", interaction.Response.StatusCode, http.StatusText(interaction.Response.StatusCode)),
+			StatusCode:    interaction.Response.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        header,
+			Body:          ioutil.NopCloser(strings.NewReader(interaction.Response.Body)),
+			ContentLength: int64(len(interaction.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+
+	return nil, fmt.Errorf("recorder: no recorded interaction matches %s %s", actual.Method, actual.URL)
+}
+
+// scrubRequest returns the scrubbed form of the request
+func (r *Recorder) scrubRequest(req *http.Request, body []byte) Request {
+	return Request{
+		Method: req.Method,
+		URL:    r.scrub(req.URL.String()),
+		Body:   r.scrub(string(body)),
+	}
+}
+
+// scrub applies all scrubbers to the passed value
+func (r *Recorder) scrub(value string) string {
+	r.mu.Lock()
+	scrubbers := r.scrubbers
+	r.mu.Unlock()
+
+	for _, s := range scrubbers {
+		value = s.pattern.ReplaceAllString(value, s.replacement)
+	}
+	return value
+}
+
+// readRequestBody reads the request body and restores it so the request can still be sent
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// readResponseBody reads the response body and restores it for the caller
+func readResponseBody(resp *http.Response) ([]byte, error) {
+	if resp.Body == nil {
+		return nil, nil
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return b, nil
+}
+
+// Exists reports whether the cassette file is present, handy for switching between record and replay mode
+func Exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
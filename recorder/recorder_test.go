@@ -0,0 +1,103 @@
+package recorder
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+const (
+	testSubscriptionId = "12345678-1234-1234-1234-1234567890ab"
+	testSecret         = "verySecretValue"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=1")
+		w.Header().Set("x-ms-request-id", "requestId")
+		w.Write([]byte(`{"properties":{"servicePrincipalProfile":{"clientId":"id","secret":"` + testSecret + `"}},"subscriptionId":"` + testSubscriptionId + `"}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cassette := filepath.Join(dir, "cassette.json")
+
+	url := server.URL + "/subscriptions/" + testSubscriptionId + "/resourceGroups/rg?api-version=1"
+
+	rec, err := New(cassette, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := autorest.DecorateSender(&http.Client{}, rec.SendDecorator())
+	req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader(`{"secret":"`+testSecret+`"}`))
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := sender.Do(req)
+	if err != nil {
+		t.Fatalf("Error during recording: %s", err)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); !strings.Contains(string(body), testSecret) {
+		t.Errorf("Expected the original body to be passed to the caller, but got %s", body)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(cassette)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leak := range []string{testSecret, testSubscriptionId, "Bearer", "session=1"} {
+		if strings.Contains(string(content), leak) {
+			t.Errorf("Cassette contains %q: %s", leak, content)
+		}
+	}
+
+	rep, err := New(cassette, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failing := autorest.SenderFunc(func(*http.Request) (*http.Response, error) {
+		t.Error("Sender called in replay mode")
+		return nil, nil
+	})
+	sender = autorest.DecorateSender(failing, rep.SendDecorator())
+
+	req, _ = http.NewRequest(http.MethodPut, url, nil)
+	resp, err = sender.Do(req)
+	if err != nil {
+		t.Fatalf("Error during replay: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("x-ms-request-id") != "requestId" {
+		t.Errorf("Unexpected replayed response: %v", resp)
+	}
+	if len(rep.Unused()) != 0 {
+		t.Errorf("Expected every interaction to be used, but got %v", rep.Unused())
+	}
+
+	req, _ = http.NewRequest(http.MethodPut, url, nil)
+	if _, err := sender.Do(req); err == nil {
+		t.Error("Expected error for unmatched request")
+	}
+}
+
+func TestScrubValue(t *testing.T) {
+	rec, err := New("unused", ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.ScrubValue("customSecret")
+
+	if scrubbed := rec.scrub("x customSecret y"); scrubbed != "x "+ScrubbedValue+" y" {
+		t.Errorf("Expected scrubbed value, but got %s", scrubbed)
+	}
+}
@@ -0,0 +1,257 @@
+// Package redact masks secrets, tokens, kubeconfigs and keys before they reach a log.
+package redact
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"unsafe"
+)
+
+// Mask replaces the redacted values
+const Mask = "[REDACTED]"
+
+// maxDepth bounds the traversal of nested values
+const maxDepth = 16
+
+// DefaultFields are the names of the sensitive struct fields and map keys, compared case-insensitively without
+// dashes and underscores
+var DefaultFields = []string{
+	"secret", "clientsecret", "password", "certificatepassword",
+	"token", "accesstoken", "refreshtoken", "authenticatedtoken",
+	"kubeconfig", "keydata", "privatekey", "clientkeydata", "clientcertificatedata",
+}
+
+// defaultPatterns match the sensitive parts of formatted text
+var defaultPatterns = []pattern{
+	{regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`), Mask},
+	{regexp.MustCompile(`ssh-(rsa|dss|ed25519|ecdsa-[a-z0-9-]+) [A-Za-z0-9+/=]+`), Mask},
+	{regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), Mask},
+	{regexp.MustCompile(`(?i)("?(client[-_]?secret|secret|password|(access|refresh)?[-_]?token|client-key-data|client-certificate-data|kube[-_]?config)"?\s*[:=]\s*"?)([^"\s,}&]+)`), "${1}" + Mask},
+}
+
+type pattern struct {
+	re          *regexp.Regexp
+	replacement string
+}
+
+// Redactor masks the sensitive fields of values and the sensitive patterns of text. It's safe for concurrent use.
+type Redactor struct {
+	lock     sync.RWMutex
+	fields   map[string]bool
+	patterns []pattern
+}
+
+// Default is the Redactor used by the clients that have no other
+var Default = New()
+
+// New creates a Redactor of DefaultFields and the default patterns
+func New() *Redactor {
+	r := &Redactor{fields: make(map[string]bool)}
+	for _, f := range DefaultFields {
+		r.fields[normalize(f)] = true
+	}
+	r.patterns = append(r.patterns, defaultPatterns...)
+	return r
+}
+
+// AddField marks the struct fields and map keys of the name as sensitive
+func (r *Redactor) AddField(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.fields[normalize(name)] = true
+}
+
+// AddPattern replaces the matches of the pattern in text with replacement, see regexp.ReplaceAllString
+func (r *Redactor) AddPattern(re *regexp.Regexp, replacement string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.patterns = append(r.patterns, pattern{re, replacement})
+}
+
+// AddValue masks every occurrence of a known secret value in text
+func (r *Redactor) AddValue(value string) {
+	if len(value) == 0 {
+		return
+	}
+	r.AddPattern(regexp.MustCompile(regexp.QuoteMeta(value)), Mask)
+}
+
+// String masks the sensitive patterns of s
+func (r *Redactor) String(s string) string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, p := range r.patterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// Sprint formats the args like fmt.Sprint after masking their sensitive fields, then masks the sensitive patterns
+func (r *Redactor) Sprint(args ...interface{}) string {
+	return r.String(fmt.Sprint(r.Values(args)...))
+}
+
+// Sprintf formats like fmt.Sprintf after masking the sensitive fields of the args, then masks the sensitive patterns
+func (r *Redactor) Sprintf(format string, args ...interface{}) string {
+	return r.String(fmt.Sprintf(format, r.Values(args)...))
+}
+
+// Values returns the args with their sensitive fields masked, see Value
+func (r *Redactor) Values(args []interface{}) []interface{} {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = r.Value(arg)
+	}
+	return values
+}
+
+// Value returns a copy of v with the sensitive fields of structs and keys of maps masked, following pointers,
+// slices and maps. Strings are masked by the sensitive patterns, other values are returned as they are.
+func (r *Redactor) Value(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if _, ok := v.(error); ok {
+		return r.String(fmt.Sprint(v))
+	}
+	if _, ok := v.(fmt.Stringer); ok {
+		return r.String(fmt.Sprint(v))
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	value := r.copy(reflect.ValueOf(v), 0)
+	if !value.IsValid() || !value.CanInterface() {
+		return v
+	}
+	return value.Interface()
+}
+
+// copy returns the masked copy of v
+func (r *Redactor) copy(v reflect.Value, depth int) reflect.Value {
+	if depth > maxDepth {
+		return v
+	}
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Elem().Type())
+		c.Elem().Set(r.copy(v.Elem(), depth+1))
+		return c
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		inner := r.copy(v.Elem(), depth+1)
+		if inner.Type().AssignableTo(v.Type()) {
+			c.Set(inner)
+			return c
+		}
+		return v
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			field := c.Field(i)
+			if !field.CanSet() {
+				// unexported fields are still printed by fmt, so the sensitive ones and strings are masked through
+				// their address in the copy, the others aren't followed
+				field = reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem()
+				if r.fields[normalize(v.Type().Field(i).Name)] {
+					mask(field)
+				} else if field.Kind() == reflect.String {
+					field.SetString(r.stringLocked(field.String()))
+				}
+				continue
+			}
+			if r.fields[normalize(v.Type().Field(i).Name)] {
+				mask(field)
+			} else {
+				field.Set(r.copy(v.Field(i), depth+1))
+			}
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() || v.Type().Elem().Kind() == reflect.Uint8 {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(r.copy(v.Index(i), depth+1))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, key := range v.MapKeys() {
+			value := reflect.New(v.Type().Elem()).Elem()
+			if key.Kind() == reflect.String && r.fields[normalize(key.String())] {
+				value.Set(v.MapIndex(key))
+				mask(value)
+			} else {
+				value.Set(r.copy(v.MapIndex(key), depth+1))
+			}
+			c.SetMapIndex(key, value)
+		}
+		return c
+	case reflect.String:
+		c := reflect.New(v.Type()).Elem()
+		c.SetString(r.stringLocked(v.String()))
+		return c
+	}
+	return v
+}
+
+// stringLocked is String for callers already holding the read lock
+func (r *Redactor) stringLocked(s string) string {
+	for _, p := range r.patterns {
+		s = p.re.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// mask replaces a settable value with Mask, or its zero value if it can't hold a string
+func mask(v reflect.Value) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.Len() != 0 {
+			v.SetString(Mask)
+		}
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && !v.IsNil() {
+			v.SetBytes([]byte(Mask))
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(v.Elem())
+		mask(c.Elem())
+		v.Set(c)
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		if masked := reflect.ValueOf(Mask); masked.Type().AssignableTo(v.Type()) {
+			v.Set(masked)
+		} else {
+			v.Set(reflect.Zero(v.Type()))
+		}
+	default:
+		v.Set(reflect.Zero(v.Type()))
+	}
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+}
@@ -0,0 +1,172 @@
+package retry
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+// Policy describes when and how ARM calls are retried
+type Policy struct {
+	// StatusCodes are the response status codes that are retried
+	StatusCodes []int
+	// NonIdempotentStatusCodes are the only status codes non-idempotent (POST, PATCH) requests are retried on.
+	// ARM rejects throttled requests before processing them, so retrying those on 429 is safe.
+	NonIdempotentStatusCodes []int
+	// MaxAttempts is the maximum number of attempts including the first one
+	MaxAttempts int
+	// Backoff is the delay before the first retry, doubled for every further retry
+	Backoff time.Duration
+	// MaxBackoff caps the exponential backoff
+	MaxBackoff time.Duration
+	// RespectRetryAfter waits for the duration in the Retry-After header instead of the backoff, if present. The wait
+	// is capped by MaxBackoff too, so a server can't stall the caller.
+	RespectRetryAfter bool
+}
+
+// Counter counts the retries of the calls made with a context carrying it, see WithCounter. It's safe for concurrent
+// use.
+type Counter struct {
+	retries int32
+}
+
+// Retries returns the number of retries counted so far
+func (c *Counter) Retries() int {
+	return int(atomic.LoadInt32(&c.retries))
+}
+
+type counterKey struct{}
+
+// WithCounter returns a copy of ctx that counts the retries of the calls made with it in c
+func WithCounter(ctx context.Context, c *Counter) context.Context {
+	return context.WithValue(ctx, counterKey{}, c)
+}
+
+// DefaultPolicy returns the policy suggested for ARM calls
+func DefaultPolicy() *Policy {
+	return &Policy{
+		StatusCodes: []int{
+			http.StatusRequestTimeout,
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+		NonIdempotentStatusCodes: []int{http.StatusTooManyRequests},
+		MaxAttempts:              4,
+		Backoff:                  2 * time.Second,
+		MaxBackoff:               time.Minute,
+		RespectRetryAfter:        true,
+	}
+}
+
+// SendDecorator returns the decorator to plug into the autorest Sender of the SDK clients
+func (p *Policy) SendDecorator() autorest.SendDecorator {
+	return func(s autorest.Sender) autorest.Sender {
+		return autorest.SenderFunc(func(r *http.Request) (resp *http.Response, err error) {
+			rr := autorest.NewRetriableRequest(r)
+			counter, _ := r.Context().Value(counterKey{}).(*Counter)
+			for attempt := 1; ; attempt++ {
+				if err = rr.Prepare(); err != nil {
+					return resp, err
+				}
+				resp, err = s.Do(rr.Request())
+
+				if attempt >= p.MaxAttempts || !p.shouldRetry(r.Method, resp, err) {
+					break
+				}
+
+				delay := p.delay(resp, attempt)
+				drain(resp)
+				select {
+				case <-time.After(delay):
+				case <-r.Context().Done():
+					return nil, r.Context().Err()
+				}
+				if counter != nil {
+					atomic.AddInt32(&counter.retries, 1)
+				}
+			}
+			return resp, err
+		})
+	}
+}
+
+// shouldRetry tells whether the outcome of the request is worth another attempt
+func (p *Policy) shouldRetry(method string, resp *http.Response, err error) bool {
+	if resp == nil {
+		// the request may have reached ARM before the connection broke
+		return err != nil && isIdempotent(method)
+	}
+	if isIdempotent(method) {
+		return autorest.ResponseHasStatusCode(resp, p.StatusCodes...)
+	}
+	return autorest.ResponseHasStatusCode(resp, p.NonIdempotentStatusCodes...)
+}
+
+// delay returns how long to wait before the next attempt
+func (p *Policy) delay(resp *http.Response, attempt int) time.Duration {
+	if p.RespectRetryAfter {
+		if d, ok := retryAfter(resp); ok {
+			if p.MaxBackoff > 0 && d > p.MaxBackoff {
+				return p.MaxBackoff
+			}
+			return d
+		}
+	}
+
+	d := p.Backoff
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if p.MaxBackoff > 0 && d >= p.MaxBackoff {
+			return p.MaxBackoff
+		}
+	}
+	return d
+}
+
+// retryAfter parses the Retry-After header, which holds either seconds or an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// isIdempotent tells whether the request can be sent again without side effects
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain discards the body of a response that is going to be retried, so the connection can be reused
+func drain(resp *http.Response) {
+	if resp != nil && resp.Body != nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
@@ -0,0 +1,94 @@
+package retry
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+func testPolicy() *Policy {
+	p := DefaultPolicy()
+	p.Backoff = time.Millisecond
+	p.MaxBackoff = 5 * time.Millisecond
+	return p
+}
+
+func TestRetry(t *testing.T) {
+	cases := []struct {
+		name        string
+		method      string
+		statusCodes []int
+		retryAfter  string
+		expCalls    int
+		expStatus   int
+		expRetries  int
+	}{
+		{name: "success", method: http.MethodGet, statusCodes: []int{200}, expCalls: 1, expStatus: 200, expRetries: 0},
+		{name: "throttled get", method: http.MethodGet, statusCodes: []int{429, 503, 200}, expCalls: 3, expStatus: 200, expRetries: 2},
+		{name: "retry after", method: http.MethodGet, statusCodes: []int{429, 200}, retryAfter: "0", expCalls: 2, expStatus: 200, expRetries: 1},
+		{name: "attempts exhausted", method: http.MethodPut, statusCodes: []int{500, 500, 500, 500, 200}, expCalls: 4, expStatus: 500, expRetries: 3},
+		{name: "not retried status", method: http.MethodDelete, statusCodes: []int{404, 200}, expCalls: 1, expStatus: 404, expRetries: 0},
+		{name: "post on 503", method: http.MethodPost, statusCodes: []int{503, 200}, expCalls: 1, expStatus: 503, expRetries: 0},
+		{name: "post on 429", method: http.MethodPost, statusCodes: []int{429, 200}, expCalls: 2, expStatus: 200, expRetries: 1},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if body, _ := ioutil.ReadAll(r.Body); string(body) != "body" {
+					t.Errorf("Expected body to be resent, but got %q", body)
+				}
+				if len(tc.retryAfter) != 0 {
+					w.Header().Set("Retry-After", tc.retryAfter)
+				}
+				w.WriteHeader(tc.statusCodes[calls])
+				calls++
+			}))
+			defer server.Close()
+
+			var counter Counter
+			req, _ := http.NewRequest(tc.method, server.URL, strings.NewReader("body"))
+			req = req.WithContext(WithCounter(context.Background(), &counter))
+			resp, err := autorest.SendWithSender(&http.Client{}, req, testPolicy().SendDecorator())
+			if err != nil {
+				t.Fatalf("Error during sending request: %s", err)
+			}
+			if calls != tc.expCalls {
+				t.Errorf("Expected %d calls, but got %d", tc.expCalls, calls)
+			}
+			if resp.StatusCode != tc.expStatus {
+				t.Errorf("Expected status %d, but got %d", tc.expStatus, resp.StatusCode)
+			}
+			if retries := counter.Retries(); retries != tc.expRetries {
+				t.Errorf("Expected %d retries, but got %d", tc.expRetries, retries)
+			}
+		})
+	}
+}
+
+func TestDelay(t *testing.T) {
+	p := &Policy{Backoff: time.Second, MaxBackoff: 5 * time.Second, RespectRetryAfter: true}
+
+	exp := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, d := range exp {
+		if actual := p.delay(nil, i+1); actual != d {
+			t.Errorf("Expected delay %s for attempt %d, but got %s", d, i+1, actual)
+		}
+	}
+
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	if actual := p.delay(resp, 1); actual != 3*time.Second {
+		t.Errorf("Expected Retry-After delay, but got %s", actual)
+	}
+	resp.Header.Set("Retry-After", "3600")
+	if actual := p.delay(resp, 1); actual != p.MaxBackoff {
+		t.Errorf("Expected Retry-After delay capped by %s, but got %s", p.MaxBackoff, actual)
+	}
+}
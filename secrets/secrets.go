@@ -0,0 +1,283 @@
+// Package secrets fetches the secrets of service principals from the environment, files or HashiCorp Vault, so they
+// don't have to be kept in long-lived fields.
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the secrets the client fetches
+const (
+	// ClientSecret is the secret of the management service principal
+	ClientSecret = "clientSecret"
+	// ClusterClientSecret is the secret of the service principal the clusters run with
+	ClusterClientSecret = "clusterClientSecret"
+)
+
+// SecretSource returns secrets by name
+type SecretSource interface {
+	Get(name string) (string, error)
+}
+
+// Identity returns the backend the source reads, e.g. so the tokens of credentials reading the same secret can be
+// shared. Sources tell it by an Identity() string method, otherwise their type is used.
+func Identity(source SecretSource) string {
+	if identified, ok := source.(interface {
+		Identity() string
+	}); ok {
+		return identified.Identity()
+	}
+	return fmt.Sprintf("%T", source)
+}
+
+// NotFoundError is returned when a source doesn't have the secret
+type NotFoundError struct {
+	Name string
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("secret %s not found", e.Name)
+}
+
+// IsNotFound returns true if err is a *NotFoundError
+func IsNotFound(err error) bool {
+	_, ok := err.(*NotFoundError)
+	return ok
+}
+
+// DefaultEnvVars maps the secret names to the environment variables of the client
+var DefaultEnvVars = map[string]string{
+	ClientSecret:        "AZURE_CLIENT_SECRET",
+	ClusterClientSecret: "AZURE_CLUSTER_CLIENT_SECRET",
+}
+
+// EnvSource reads secrets from environment variables. Vars maps secret names to variable names, defaulting to
+// DefaultEnvVars; names missing from it are used as variable names.
+type EnvSource struct {
+	Vars map[string]string
+}
+
+func (s *EnvSource) Get(name string) (string, error) {
+	vars := s.Vars
+	if vars == nil {
+		vars = DefaultEnvVars
+	}
+	key, ok := vars[name]
+	if !ok {
+		key = name
+	}
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return "", &NotFoundError{Name: name}
+	}
+	return value, nil
+}
+
+// Identity returns the environment variables of the secrets
+func (s *EnvSource) Identity() string {
+	vars := s.Vars
+	if vars == nil {
+		vars = DefaultEnvVars
+	}
+	var pairs []string
+	for name, key := range vars {
+		pairs = append(pairs, name+"="+key)
+	}
+	sort.Strings(pairs)
+	return "env:" + strings.Join(pairs, ",")
+}
+
+// FileSource reads each secret from the file of its name in Dir, like the secret volumes of Kubernetes. Surrounding
+// whitespace is trimmed.
+type FileSource struct {
+	Dir string
+}
+
+func (s *FileSource) Get(name string) (string, error) {
+	if len(name) == 0 || strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(s.Dir, name))
+	if os.IsNotExist(err) {
+		return "", &NotFoundError{Name: name}
+	} else if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// Identity returns the directory of the secret files
+func (s *FileSource) Identity() string {
+	dir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		dir = s.Dir
+	}
+	return "file:" + dir
+}
+
+// DefaultVaultClient is the HTTP client of the VaultSources without a Client
+var DefaultVaultClient = &http.Client{Timeout: 30 * time.Second}
+
+// VaultSource reads secrets from the fields of a HashiCorp Vault KV secret, version 1 or 2. Address and Token
+// default to the VAULT_ADDR and VAULT_TOKEN environment variables.
+type VaultSource struct {
+	Address string
+	Token   string
+	// Path of the KV secret, e.g. secret/data/aks for version 2 of the KV engine mounted at secret
+	Path string
+	// Client sends the requests to Vault, DefaultVaultClient if nil
+	Client *http.Client
+}
+
+// Identity returns the address of the Vault server and the path of the secret
+func (s *VaultSource) Identity() string {
+	return "vault:" + s.url()
+}
+
+// url returns the address of the KV secret
+func (s *VaultSource) url() string {
+	address := s.Address
+	if len(address) == 0 {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	return strings.TrimRight(address, "/") + "/v1/" + strings.TrimLeft(s.Path, "/")
+}
+
+func (s *VaultSource) Get(name string) (string, error) {
+	token := s.Token
+	if len(token) == 0 {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	client := s.Client
+	if client == nil {
+		client = DefaultVaultClient
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.url(), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("vault request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Data   map[string]interface{} `json:"data"`
+		Errors []string               `json:"errors"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil && resp.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("failed to decode vault response: %s", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return "", &NotFoundError{Name: name}
+	case resp.StatusCode != http.StatusOK:
+		return "", fmt.Errorf("vault returned %d: %s", resp.StatusCode, strings.Join(body.Errors, ", "))
+	}
+
+	data := body.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[name].(string)
+	if !ok {
+		return "", &NotFoundError{Name: name}
+	}
+	return value, nil
+}
+
+// CachedSource caches the secrets of a source for TTL, so the source isn't called on every use. A secret is fetched
+// once at a time, without blocking the reads of the other secrets.
+type CachedSource struct {
+	source SecretSource
+	ttl    time.Duration
+	now    func() time.Time
+
+	lock    sync.Mutex
+	entries map[string]cacheEntry
+	fetches map[string]*fetch
+	// generation is increased by Purge, so the fetches started before don't cache their secrets
+	generation int
+}
+
+type cacheEntry struct {
+	value   string
+	expires time.Time
+}
+
+// fetch is a call of the source in progress, its result is set when done is closed
+type fetch struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// NewCachedSource caches the secrets of source for ttl
+func NewCachedSource(source SecretSource, ttl time.Duration) *CachedSource {
+	return &CachedSource{
+		source:  source,
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]cacheEntry),
+		fetches: make(map[string]*fetch),
+	}
+}
+
+func (s *CachedSource) Get(name string) (string, error) {
+	s.lock.Lock()
+	if entry, ok := s.entries[name]; ok && s.now().Before(entry.expires) {
+		s.lock.Unlock()
+		return entry.value, nil
+	}
+	// wait for the fetch of another caller instead of calling the source again
+	if f, ok := s.fetches[name]; ok {
+		s.lock.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	f := &fetch{done: make(chan struct{})}
+	s.fetches[name] = f
+	generation := s.generation
+	s.lock.Unlock()
+
+	f.value, f.err = s.source.Get(name)
+
+	s.lock.Lock()
+	if s.fetches[name] == f {
+		delete(s.fetches, name)
+	}
+	if f.err == nil && generation == s.generation {
+		s.entries[name] = cacheEntry{value: f.value, expires: s.now().Add(s.ttl)}
+	}
+	s.lock.Unlock()
+	close(f.done)
+	return f.value, f.err
+}
+
+// Identity returns the identity of the cached source
+func (s *CachedSource) Identity() string {
+	return Identity(s.source)
+}
+
+// Purge drops the cached secrets, e.g. after a rotation
+func (s *CachedSource) Purge() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = make(map[string]cacheEntry)
+	s.fetches = make(map[string]*fetch)
+	s.generation++
+}
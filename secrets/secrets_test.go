@@ -0,0 +1,182 @@
+package secrets
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEnvSource(t *testing.T) {
+	defer os.Setenv("AZURE_CLIENT_SECRET", os.Getenv("AZURE_CLIENT_SECRET"))
+	os.Setenv("AZURE_CLIENT_SECRET", "envSecret")
+	os.Setenv("CUSTOM_SECRET", "customSecret")
+	defer os.Unsetenv("CUSTOM_SECRET")
+
+	if value, err := (&EnvSource{}).Get(ClientSecret); err != nil || value != "envSecret" {
+		t.Errorf("Expected envSecret, but got %q, %v", value, err)
+	}
+	custom := &EnvSource{Vars: map[string]string{ClientSecret: "CUSTOM_SECRET"}}
+	if value, err := custom.Get(ClientSecret); err != nil || value != "customSecret" {
+		t.Errorf("Expected customSecret, but got %q, %v", value, err)
+	}
+	if _, err := (&EnvSource{}).Get("MISSING_SECRET"); !IsNotFound(err) {
+		t.Errorf("Expected not found error, but got %v", err)
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, ClientSecret), []byte("fileSecret\n"), 0600)
+
+	source := &FileSource{Dir: dir}
+	if value, err := source.Get(ClientSecret); err != nil || value != "fileSecret" {
+		t.Errorf("Expected fileSecret, but got %q, %v", value, err)
+	}
+	if _, err := source.Get(ClusterClientSecret); !IsNotFound(err) {
+		t.Errorf("Expected not found error, but got %v", err)
+	}
+	if _, err := source.Get("../" + ClientSecret); err == nil || IsNotFound(err) {
+		t.Errorf("Expected invalid name error, but got %v", err)
+	}
+}
+
+func TestVaultSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vaultToken" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/aks":
+			w.Write([]byte(`{"data":{"data":{"clientSecret":"v2Secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv/aks":
+			w.Write([]byte(`{"data":{"clientSecret":"v1Secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name     string
+		source   *VaultSource
+		expected string
+		notFound bool
+		fails    bool
+	}{
+		{name: "kv v2", source: &VaultSource{Address: server.URL, Token: "vaultToken", Path: "secret/data/aks"}, expected: "v2Secret"},
+		{name: "kv v1", source: &VaultSource{Address: server.URL + "/", Token: "vaultToken", Path: "/kv/aks"}, expected: "v1Secret"},
+		{name: "missing path", source: &VaultSource{Address: server.URL, Token: "vaultToken", Path: "kv/other"}, notFound: true},
+		{name: "forbidden", source: &VaultSource{Address: server.URL, Token: "wrong", Path: "kv/aks"}, fails: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			value, err := tc.source.Get(ClientSecret)
+			switch {
+			case tc.notFound:
+				if !IsNotFound(err) {
+					t.Errorf("Expected not found error, but got %v", err)
+				}
+			case tc.fails:
+				if err == nil || IsNotFound(err) {
+					t.Errorf("Expected error, but got %v", err)
+				}
+			case err != nil || value != tc.expected:
+				t.Errorf("Expected %s, but got %q, %v", tc.expected, value, err)
+			}
+		})
+	}
+}
+
+type countingSource struct {
+	calls int
+}
+
+func (s *countingSource) Get(name string) (string, error) {
+	s.calls++
+	return name, nil
+}
+
+func TestCachedSource(t *testing.T) {
+	source := &countingSource{}
+	cached := NewCachedSource(source, time.Minute)
+	now := time.Now()
+	cached.now = func() time.Time { return now }
+
+	cached.Get(ClientSecret)
+	cached.Get(ClientSecret)
+	if source.calls != 1 {
+		t.Errorf("Expected one call within TTL, but got %d", source.calls)
+	}
+	now = now.Add(time.Minute)
+	cached.Get(ClientSecret)
+	if source.calls != 2 {
+		t.Errorf("Expected a call after TTL, but got %d", source.calls)
+	}
+	cached.Purge()
+	cached.Get(ClientSecret)
+	if source.calls != 3 {
+		t.Errorf("Expected a call after purge, but got %d", source.calls)
+	}
+}
+
+// blockingSource blocks the secrets in its block map until their channel is closed
+type blockingSource struct {
+	block map[string]chan struct{}
+	calls int32
+}
+
+func (s *blockingSource) Get(name string) (string, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if c, ok := s.block[name]; ok {
+		<-c
+	}
+	return name, nil
+}
+
+func TestCachedSourceDoesntBlock(t *testing.T) {
+	release := make(chan struct{})
+	source := &blockingSource{block: map[string]chan struct{}{ClientSecret: release}}
+	cached := NewCachedSource(source, time.Minute)
+
+	results := make(chan string, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			value, _ := cached.Get(ClientSecret)
+			results <- value
+		}()
+	}
+
+	// a slow secret doesn't block the others
+	done := make(chan struct{})
+	go func() {
+		cached.Get(ClusterClientSecret)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the other secret not to wait for the slow one")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if value := <-results; value != ClientSecret {
+			t.Errorf("Expected the fetched secret, but got %q", value)
+		}
+	}
+	if calls := atomic.LoadInt32(&source.calls); calls != 2 {
+		t.Errorf("Expected one call per secret, but got %d", calls)
+	}
+}
@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/adal"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// HeaderCorrelationID is the response header ARM returns the correlation ID of a call in
+const HeaderCorrelationID = "x-ms-correlation-request-id"
+
+// ARM error codes used by the helpers
+const (
+	ErrCodeNotFound              = "NotFound"
+	ErrCodeResourceNotFound      = "ResourceNotFound"
+	ErrCodeResourceGroupNotFound = "ResourceGroupNotFound"
+	ErrCodeConflict              = "Conflict"
+	ErrCodeOperationNotAllowed   = "OperationNotAllowed"
+	ErrCodeQuotaExceeded         = "QuotaExceeded"
+	ErrCodeThrottled             = "SubscriptionRequestsThrottled"
+	ErrCodeTooManyRequests       = "TooManyRequests"
+)
+
+// ErrorDetail is a nested ARM error
+type ErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Target  string `json:"target"`
+}
+
+// ConvertError converts the errors of the Azure SDK (autorest.DetailedError, azure.RequestError, azure.ServiceError
+// and adal.TokenRefreshError) into an *AKSError. Other errors, including nil, are returned unchanged.
+func ConvertError(err error) error {
+	switch e := err.(type) {
+	case *AKSError:
+		return e
+	case autorest.DetailedError:
+		return convertDetailedError(e)
+	case *autorest.DetailedError:
+		return convertDetailedError(*e)
+	case azure.RequestError:
+		return convertRequestError(&e)
+	case *azure.RequestError:
+		return convertRequestError(e)
+	case azure.ServiceError:
+		return convertServiceError(&e, nil)
+	case *azure.ServiceError:
+		return convertServiceError(e, nil)
+	case adal.TokenRefreshError:
+		return newErrFromResponse(e.Error(), e.Response())
+	default:
+		return err
+	}
+}
+
+// convertDetailedError converts the error the SDK clients wrap everything in
+func convertDetailedError(e autorest.DetailedError) error {
+	if e.Original != nil {
+		if converted, ok := ConvertError(e.Original).(*AKSError); ok {
+			fillFromResponse(converted, e.Response)
+			return converted
+		}
+	}
+
+	aksErr := newErrFromResponse(e.Error(), e.Response)
+	if statusCode, ok := e.StatusCode.(int); ok && aksErr.StatusCode == 0 {
+		aksErr.StatusCode = statusCode
+		aksErr.Retryable = isRetryable(aksErr)
+	}
+	return aksErr
+}
+
+// convertRequestError converts an error response of ARM
+func convertRequestError(e *azure.RequestError) error {
+	aksErr := convertServiceError(e.ServiceError, e.Response)
+	if len(aksErr.RequestID) == 0 {
+		aksErr.RequestID = e.RequestID
+	}
+	if statusCode, ok := e.StatusCode.(int); ok && aksErr.StatusCode == 0 {
+		aksErr.StatusCode = statusCode
+		aksErr.Retryable = isRetryable(aksErr)
+	}
+	return aksErr
+}
+
+// convertServiceError converts the error object of an ARM error response
+func convertServiceError(se *azure.ServiceError, resp *http.Response) *AKSError {
+	if se == nil {
+		return newErrFromResponse("", resp)
+	}
+
+	ase := AzureServerError{
+		Code:    se.Code,
+		Message: se.Message,
+	}
+	if se.Target != nil {
+		ase.Target = *se.Target
+	}
+	for _, d := range se.Details {
+		ase.Details = append(ase.Details, ErrorDetail{
+			Code:    ensureValueString(d["code"]),
+			Message: ensureValueString(d["message"]),
+			Target:  ensureValueString(d["target"]),
+		})
+	}
+
+	aksErr := newErrFromServerError(0, ase)
+	fillFromResponse(aksErr, resp)
+	return aksErr
+}
+
+// newErrFromServerError creates an *AKSError from a parsed ARM error object
+func newErrFromServerError(statusCode int, ase AzureServerError) *AKSError {
+	aksErr := &AKSError{
+		StatusCode: statusCode,
+		Message:    ase.Message,
+		Code:       ase.Code,
+		Target:     ase.Target,
+		Details:    ase.Details,
+	}
+	aksErr.Retryable = isRetryable(aksErr)
+	return aksErr
+}
+
+// newErrFromResponse creates an *AKSError from a response that has no ARM error object
+func newErrFromResponse(message string, resp *http.Response) *AKSError {
+	aksErr := &AKSError{Message: message}
+	fillFromResponse(aksErr, resp)
+	return aksErr
+}
+
+// fillFromResponse sets the status code and IDs from the response, if they are not set yet
+func fillFromResponse(e *AKSError, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	if e.StatusCode == 0 {
+		e.StatusCode = resp.StatusCode
+	}
+	if len(e.RequestID) == 0 {
+		e.RequestID = resp.Header.Get(azure.HeaderRequestID)
+	}
+	if len(e.CorrelationID) == 0 {
+		e.CorrelationID = resp.Header.Get(HeaderCorrelationID)
+	}
+	e.Retryable = isRetryable(e)
+}
+
+// isRetryable tells whether retrying the failed call might help
+func isRetryable(e *AKSError) bool {
+	switch e.StatusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return hasCode(e, ErrCodeThrottled, ErrCodeTooManyRequests)
+}
+
+// hasCode tells whether the error or one of its details has any of the codes
+func hasCode(e *AKSError, codes ...string) bool {
+	for _, code := range codes {
+		if strings.EqualFold(e.Code, code) {
+			return true
+		}
+		for _, d := range e.Details {
+			if strings.EqualFold(d.Code, code) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// asAKSError converts the error and tells whether it's an *AKSError
+func asAKSError(err error) (*AKSError, bool) {
+	aksErr, ok := ConvertError(err).(*AKSError)
+	return aksErr, ok && aksErr != nil
+}
+
+// IsNotFound tells whether the error means the resource doesn't exist
+func IsNotFound(err error) bool {
+	e, ok := asAKSError(err)
+	return ok && (e.StatusCode == http.StatusNotFound ||
+		hasCode(e, ErrCodeNotFound, ErrCodeResourceNotFound, ErrCodeResourceGroupNotFound))
+}
+
+// IsConflict tells whether the error means the resource is in a conflicting state, e.g. another operation is running
+func IsConflict(err error) bool {
+	e, ok := asAKSError(err)
+	return ok && (e.StatusCode == http.StatusConflict || hasCode(e, ErrCodeConflict))
+}
+
+// IsQuotaExceeded tells whether the error means a subscription quota (e.g. cores) would be exceeded
+func IsQuotaExceeded(err error) bool {
+	e, ok := asAKSError(err)
+	if !ok {
+		return false
+	}
+	if hasCode(e, ErrCodeQuotaExceeded) {
+		return true
+	}
+	return hasCode(e, ErrCodeOperationNotAllowed) && strings.Contains(strings.ToLower(e.Message), "quota")
+}
+
+// IsThrottled tells whether the error means ARM throttled the call
+func IsThrottled(err error) bool {
+	e, ok := asAKSError(err)
+	return ok && (e.StatusCode == http.StatusTooManyRequests || hasCode(e, ErrCodeThrottled, ErrCodeTooManyRequests))
+}
+
+// IsRetryable tells whether retrying the failed call might help
+func IsRetryable(err error) bool {
+	e, ok := asAKSError(err)
+	return ok && e.Retryable
+}
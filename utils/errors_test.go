@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+func sdkError(statusCode int, body string) error {
+	resp := &http.Response{
+		StatusCode: statusCode,
+		Header: http.Header{
+			http.CanonicalHeaderKey(azure.HeaderRequestID): []string{"requestId"},
+			http.CanonicalHeaderKey(HeaderCorrelationID):   []string{"correlationId"},
+		},
+		Body: ioutil.NopCloser(strings.NewReader(body)),
+	}
+	err := autorest.Respond(resp, azure.WithErrorUnlessStatusCode(http.StatusOK))
+	return autorest.NewErrorWithError(err, "containerservice.ManagedClustersClient", "Get", resp, "Failure responding to request")
+}
+
+func TestConvertError(t *testing.T) {
+	err := ConvertError(sdkError(http.StatusBadRequest, `{"error":{"code":"InvalidParameter","message":"bad","target":"agentPoolProfiles","details":[{"code":"QuotaExceeded","message":"cores"}]}}`))
+
+	aksErr, ok := err.(*AKSError)
+	if !ok {
+		t.Fatalf("Expected *AKSError, but got %T", err)
+	}
+	exp := AKSError{
+		StatusCode:    http.StatusBadRequest,
+		Message:       "bad",
+		Code:          "InvalidParameter",
+		Target:        "agentPoolProfiles",
+		Details:       []ErrorDetail{{Code: "QuotaExceeded", Message: "cores"}},
+		RequestID:     "requestId",
+		CorrelationID: "correlationId",
+	}
+	if aksErr.StatusCode != exp.StatusCode || aksErr.Message != exp.Message || aksErr.Code != exp.Code ||
+		aksErr.Target != exp.Target || len(aksErr.Details) != 1 || aksErr.Details[0] != exp.Details[0] ||
+		aksErr.RequestID != exp.RequestID || aksErr.CorrelationID != exp.CorrelationID || aksErr.Retryable {
+		t.Errorf("Expected error %#v, but got %#v", exp, *aksErr)
+	}
+
+	if ConvertError(nil) != nil {
+		t.Error("Expected nil error to stay nil")
+	}
+}
+
+func TestErrorHelpers(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		exp  func(error) bool
+	}{
+		{name: "not found", err: sdkError(http.StatusNotFound, `{"error":{"code":"ResourceNotFound","message":"missing"}}`), exp: IsNotFound},
+		{name: "conflict", err: sdkError(http.StatusConflict, `{"error":{"code":"Conflict"}}`), exp: IsConflict},
+		{name: "quota", err: sdkError(http.StatusBadRequest, `{"error":{"code":"OperationNotAllowed","message":"Operation results in exceeding quota limits of Core."}}`), exp: IsQuotaExceeded},
+		{name: "throttled", err: sdkError(http.StatusTooManyRequests, `{"error":{"code":"SubscriptionRequestsThrottled"}}`), exp: IsThrottled},
+		{name: "retryable", err: sdkError(http.StatusServiceUnavailable, `{}`), exp: IsRetryable},
+		{name: "value", err: CreateErrorFromValue(http.StatusNotFound, []byte(`{"error":{"code":"NotFound","message":"missing"}}`)), exp: IsNotFound},
+	}
+
+	helpers := []func(error) bool{IsNotFound, IsConflict, IsQuotaExceeded, IsThrottled}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if !tc.exp(tc.err) {
+				t.Errorf("Expected helper to match %v", tc.err)
+			}
+			matches := 0
+			for _, h := range helpers {
+				if h(tc.err) {
+					matches++
+				}
+			}
+			if matches > 1 {
+				t.Errorf("Expected at most one helper to match %v, but got %d", tc.err, matches)
+			}
+		})
+	}
+}
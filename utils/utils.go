@@ -33,12 +33,27 @@ func NewErr(params ...interface{}) *AKSError {
 	}
 }
 
+// AKSError is the error returned for failed Azure calls, see ConvertError
 type AKSError struct {
 	StatusCode int
 	Message    string
+	// Code is the ARM error code, e.g. ResourceNotFound
+	Code string
+	// Target is the ARM error target, e.g. the invalid property
+	Target string
+	// Details are the nested ARM errors
+	Details []ErrorDetail
+	// RequestID and CorrelationID identify the failed call for Azure support
+	RequestID     string
+	CorrelationID string
+	// Retryable tells whether retrying the call might help
+	Retryable bool
 }
 
 func (e *AKSError) Error() string {
+	if len(e.Message) == 0 {
+		return e.Code
+	}
 	return e.Message
 }
 
@@ -50,15 +65,31 @@ func ToJSON(v interface{}) (string, error) {
 }
 
 // NewServicePrincipalTokenFromCredentials creates a new ServicePrincipalToken using values of the
-// passed credentials map.
+// passed credentials map. The AD endpoint is taken from the AZURE_ENVIRONMENT_FILEPATH or AZURE_ENVIRONMENT
+// entries, defaulting to the public cloud.
 func NewServicePrincipalTokenFromCredentials(c map[string]string, scope string) (*adal.ServicePrincipalToken, error) {
-	oauthConfig, err := adal.NewOAuthConfig(azure.PublicCloud.ActiveDirectoryEndpoint, c["AZURE_TENANT_ID"])
+	env, err := environmentFromCredentials(c)
+	if err != nil {
+		return nil, err
+	}
+	oauthConfig, err := adal.NewOAuthConfig(env.ActiveDirectoryEndpoint, c["AZURE_TENANT_ID"])
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	return adal.NewServicePrincipalToken(*oauthConfig, c["AZURE_CLIENT_ID"], c["AZURE_CLIENT_SECRET"], scope)
 }
 
+// environmentFromCredentials returns the Azure cloud of the passed credentials map
+func environmentFromCredentials(c map[string]string) (azure.Environment, error) {
+	if path := c[azure.EnvironmentFilepathName]; len(path) != 0 {
+		return azure.EnvironmentFromFile(path)
+	}
+	if name := c["AZURE_ENVIRONMENT"]; len(name) != 0 {
+		return azure.EnvironmentFromName(name)
+	}
+	return azure.PublicCloud, nil
+}
+
 func ensureValueStrings(mapOfInterface map[string]interface{}) map[string]string {
 	mapOfStrings := make(map[string]string)
 	for key, value := range mapOfInterface {
@@ -93,24 +124,27 @@ func S(input string) *string {
 }
 
 type AzureServerError struct {
-	Message string `json:"message"`
+	Code    string        `json:"code"`
+	Message string        `json:"message"`
+	Target  string        `json:"target"`
+	Details []ErrorDetail `json:"details"`
 }
 
+// CreateErrorFromValue creates an *AKSError from the status code and body of a failed ARM response
 func CreateErrorFromValue(statusCode int, v []byte) error {
+	ase := AzureServerError{}
 	if statusCode == http.StatusBadRequest {
-		ase := AzureServerError{}
 		json.Unmarshal([]byte(v), &ase)
-		if len(ase.Message) != 0 {
-			return NewErr(ase.Message, statusCode)
-		}
 	}
 
-	type TempError struct {
-		Error struct {
-			Message string `json:"message"`
-		} `json:"error"`
+	if len(ase.Message) == 0 {
+		type TempError struct {
+			Error AzureServerError `json:"error"`
+		}
+		tempError := TempError{}
+		json.Unmarshal([]byte(v), &tempError)
+		ase = tempError.Error
 	}
-	tempError := TempError{}
-	json.Unmarshal([]byte(v), &tempError)
-	return NewErr(tempError.Error.Message, statusCode)
+
+	return newErrFromServerError(statusCode, ase)
 }